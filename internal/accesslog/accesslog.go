@@ -0,0 +1,137 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+// Stdout is the path to write access log to standard output.
+const Stdout = "stdout"
+
+var (
+	_mu     sync.Mutex
+	_writer io.WriteCloser
+)
+
+// Entry is one line of access log, written when a connection
+// is closed or failed to dial.
+type Entry struct {
+	Start           time.Time         `json:"start"`
+	End             time.Time         `json:"end"`
+	Network         string            `json:"network"`
+	SourceIP        net.IP            `json:"sourceIP"`
+	SourcePort      uint16            `json:"sourcePort"`
+	DestinationIP   net.IP            `json:"destinationIP"`
+	DestinationPort uint16            `json:"destinationPort"`
+	Host            string            `json:"host,omitempty"`
+	OrigDst         string            `json:"originalDestination,omitempty"`
+	Rule            string            `json:"rule,omitempty"`
+	Outbound        string            `json:"outbound,omitempty"`
+	DialerIP        net.IP            `json:"dialerIP,omitempty"`
+	DialerPort      uint16            `json:"dialerPort,omitempty"`
+	Process         string            `json:"process,omitempty"`
+	Tags            map[string]string `json:"tags,omitempty"`
+	Upload          int64             `json:"upload"`
+	Download        int64             `json:"download"`
+	Reason          string            `json:"reason,omitempty"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// NewEntry returns an Entry filled with fields of metadata.
+func NewEntry(metadata *adapter.Metadata) *Entry {
+	return &Entry{
+		Network:         metadata.Net.String(),
+		SourceIP:        metadata.SrcIP,
+		SourcePort:      metadata.SrcPort,
+		DestinationIP:   metadata.DstIP,
+		DestinationPort: metadata.DstPort,
+		Host:            metadata.Host,
+		OrigDst:         metadata.OrigDst,
+		Rule:            metadata.Rule,
+		Outbound:        metadata.Outbound,
+		DialerIP:        metadata.MidIP,
+		DialerPort:      metadata.MidPort,
+		Process:         metadata.Process,
+		Tags:            metadata.Tags,
+	}
+}
+
+// Open starts writing access log to path, or to standard output
+// if path is Stdout. The file is rotated when it would exceed
+// maxSize bytes, keeping at most backups old files; zero maxSize
+// disables rotation.
+func Open(path string, maxSize int64, backups int) error {
+	var w io.WriteCloser
+	if strings.ToLower(path) == Stdout {
+		w = nopCloser{os.Stdout}
+	} else {
+		rw, err := newRotateWriter(path, maxSize, backups)
+		if err != nil {
+			return err
+		}
+		w = rw
+	}
+
+	_mu.Lock()
+	old := _writer
+	_writer = w
+	_mu.Unlock()
+
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Close stops writing access log.
+func Close() error {
+	_mu.Lock()
+	w := _writer
+	_writer = nil
+	_mu.Unlock()
+
+	if w != nil {
+		return w.Close()
+	}
+	return nil
+}
+
+// Enabled reports whether access log is opened, callers could
+// skip building entries if it's not.
+func Enabled() bool {
+	_mu.Lock()
+	defer _mu.Unlock()
+	return _writer != nil
+}
+
+// Write writes e as one JSON line.
+func Write(e *Entry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	_mu.Lock()
+	defer _mu.Unlock()
+	if _writer == nil {
+		return
+	}
+	if _, err := _writer.Write(b); err != nil {
+		log.Warnf("[ACCESS] write error: %v", err)
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,109 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+)
+
+// rotateWriter writes to file of path, and renames it to
+// path.1, path.2... when it reaches maxSize. Callers must
+// serialize calls of rotateWriter.
+type rotateWriter struct {
+	path    string
+	maxSize int64
+	backups int
+
+	file *os.File
+	size int64
+}
+
+func newRotateWriter(path string, maxSize int64, backups int) (*rotateWriter, error) {
+	if maxSize < 0 {
+		return nil, fmt.Errorf("invalid max size: %d", maxSize)
+	}
+	if backups < 0 {
+		return nil, fmt.Errorf("invalid backups: %d", backups)
+	}
+
+	w := &rotateWriter{
+		path:    path,
+		maxSize: maxSize,
+		backups: backups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotateWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1, the
+// oldest file is removed, then opens a new file of path.
+func (w *rotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if w.backups == 0 {
+		if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		for i := w.backups - 1; i > 0; i-- {
+			src := fmt.Sprintf("%s.%d", w.path, i)
+			dst := fmt.Sprintf("%s.%d", w.path, i+1)
+			if err := os.Rename(src, dst); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return w.open()
+}
+
+func (w *rotateWriter) Write(b []byte) (int, error) {
+	if w.file == nil { /* reopen after failed rotation */
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(b)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			w.file = nil
+			return 0, fmt.Errorf("rotate %s: %w", w.path, err)
+		}
+	}
+
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotateWriter) Close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
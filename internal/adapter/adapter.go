@@ -1,6 +1,13 @@
 package adapter
 
-import "net"
+import (
+	"errors"
+	"net"
+)
+
+// ErrCloseWriteUnsupported is returned by CloseWrite of conns
+// which can't be half-closed.
+var ErrCloseWriteUnsupported = errors.New("close write unsupported")
 
 type TCPConn interface {
 	net.Conn
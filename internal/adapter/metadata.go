@@ -37,6 +37,28 @@ type Metadata struct {
 	MidPort uint16  `json:"dialerPort"`
 	DstPort uint16  `json:"destinationPort"`
 	Host    string  `json:"host"`
+
+	// Rule and Outbound record the routing decision of
+	// this connection, filled by the tunnel before dialing.
+	Rule     string `json:"rule"`
+	Outbound string `json:"outbound"`
+
+	// Process, PID and UID describe the local process which
+	// owns the connection, PID is zero if it's not found.
+	Process string `json:"process"`
+	PID     uint32 `json:"pid"`
+	UID     uint32 `json:"uid"`
+
+	// Timeout is filled with the effective timeouts by
+	// stack and tunnel, overrides of port are applied.
+	Timeout Timeout `json:"timeout"`
+
+	// OrigDst is the original destination address if it's
+	// rewritten by DNAT rules.
+	OrigDst string `json:"originalDestination,omitempty"`
+
+	// Tags are attached by hooks of embedders.
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 func (m *Metadata) DestinationAddress() string {
@@ -0,0 +1,32 @@
+package adapter
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Timeout records the effective timeouts of connection, zero
+// means the timeout doesn't apply to network of connection.
+type Timeout struct {
+	Connect           time.Duration
+	Wait              time.Duration
+	Idle              time.Duration
+	KeepaliveIdle     time.Duration
+	KeepaliveInterval time.Duration
+}
+
+func (t Timeout) MarshalJSON() ([]byte, error) {
+	m := make(map[string]string)
+	for name, d := range map[string]time.Duration{
+		"connect":           t.Connect,
+		"wait":              t.Wait,
+		"idle":              t.Idle,
+		"keepaliveIdle":     t.KeepaliveIdle,
+		"keepaliveInterval": t.KeepaliveInterval,
+	} {
+		if d != 0 {
+			m[name] = d.String()
+		}
+	}
+	return json.Marshal(m)
+}
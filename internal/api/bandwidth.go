@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/xjasonlyu/tun2socks/internal/manager"
+	"github.com/xjasonlyu/tun2socks/internal/proxy"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+func bandwidthRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", getBandwidth)
+	r.Put("/", updateBandwidth)
+	return r
+}
+
+func getBandwidth(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, manager.DefaultManager.Bandwidth())
+}
+
+func updateBandwidth(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Global    *manager.Bandwidth           `json:"global"`
+		PerSource *manager.Bandwidth           `json:"perSource"`
+		Outbounds map[string]manager.Bandwidth `json:"outbounds"`
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	for name, bw := range req.Outbounds {
+		if _, ok := proxy.Lookup(name); !ok {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, newError(fmt.Sprintf("outbound %s not found", name)))
+			return
+		}
+		if bw.Upload < 0 || bw.Download < 0 {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, ErrBadRequest)
+			return
+		}
+	}
+
+	for _, bw := range []*manager.Bandwidth{req.Global, req.PerSource} {
+		if bw != nil && (bw.Upload < 0 || bw.Download < 0) {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, ErrBadRequest)
+			return
+		}
+	}
+
+	if req.Global != nil {
+		manager.DefaultManager.SetBandwidth(*req.Global)
+		log.Infof("[API] set global bandwidth to %s", req.Global)
+	}
+	if req.PerSource != nil {
+		manager.DefaultManager.SetSourceBandwidth(*req.PerSource)
+		log.Infof("[API] set bandwidth per source to %s", req.PerSource)
+	}
+	for name, bw := range req.Outbounds {
+		manager.DefaultManager.SetOutboundBandwidth(name, bw)
+		log.Infof("[API] set bandwidth of %s to %s", name, bw)
+	}
+	render.NoContent(w, r)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/xjasonlyu/tun2socks/internal/rule"
+)
+
+func clientRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", getClients)
+	r.Put("/", updateClient)
+	r.Delete("/", deleteClient)
+	r.Put("/default", updateDefaultClient)
+	return r
+}
+
+func getClients(w http.ResponseWriter, r *http.Request) {
+	def, clients := rule.Clients()
+	render.JSON(w, r, render.M{
+		"default": def,
+		"clients": clients,
+	})
+}
+
+func updateClient(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Source   string `json:"source"`
+		Outbound string `json:"outbound"`
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	if err := rule.SetClient(req.Source, req.Outbound); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	render.NoContent(w, r)
+}
+
+func deleteClient(w http.ResponseWriter, r *http.Request) {
+	source := r.URL.Query().Get("source")
+	if err := rule.RemoveClient(source); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, rule.ErrClientNotFound) {
+			status = http.StatusNotFound
+		}
+		render.Status(r, status)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	render.NoContent(w, r)
+}
+
+func updateDefaultClient(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Outbound string `json:"outbound"`
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	if err := rule.SetDefaultClient(req.Outbound); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+	render.NoContent(w, r)
+}
@@ -76,7 +76,7 @@ func closeConnection(w http.ResponseWriter, r *http.Request) {
 	snapshot := manager.DefaultManager.Snapshot()
 	for _, c := range snapshot.Connections {
 		if id == c.ID() {
-			_ = c.Close()
+			_ = c.CloseWithReason(manager.ReasonAPI)
 			break
 		}
 	}
@@ -86,7 +86,7 @@ func closeConnection(w http.ResponseWriter, r *http.Request) {
 func closeAllConnections(w http.ResponseWriter, r *http.Request) {
 	snapshot := manager.DefaultManager.Snapshot()
 	for _, c := range snapshot.Connections {
-		_ = c.Close()
+		_ = c.CloseWithReason(manager.ReasonAPI)
 	}
 	render.NoContent(w, r)
 }
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/xjasonlyu/tun2socks/internal/proxy"
+	"github.com/xjasonlyu/tun2socks/internal/tunnel"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+func dnatRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", getDNAT)
+	r.Put("/", updateDNAT)
+	return r
+}
+
+func getDNAT(w http.ResponseWriter, r *http.Request) {
+	rules := tunnel.GetDNATRules()
+	if rules == nil {
+		rules = []*tunnel.DNATRule{}
+	}
+	render.JSON(w, r, render.M{"rules": rules})
+}
+
+func updateDNAT(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Rules []string `json:"rules"`
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	rules := make([]*tunnel.DNATRule, 0, len(req.Rules))
+	for _, raw := range req.Rules {
+		rule, err := tunnel.ParseDNATRule(raw)
+		if err != nil {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, newError(err.Error()))
+			return
+		}
+		if _, ok := proxy.Lookup(rule.Outbound); rule.Outbound != "" && !ok {
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, newError(fmt.Sprintf("outbound %s not found", rule.Outbound)))
+			return
+		}
+		rules = append(rules, rule)
+	}
+
+	tunnel.SetDNATRules(rules)
+	log.Infof("[API] set %d DNAT rules", len(rules))
+	render.NoContent(w, r)
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/xjasonlyu/tun2socks/internal/tunnel"
+)
+
+func getLimits(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, tunnel.GetLimitStatus())
+}
+
+func getHooks(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, tunnel.GetHookStatus())
+}
+
+func getDNSLeak(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, tunnel.GetDNSLeakStatus())
+}
+
+func getQueues(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{
+		"udp":        tunnel.GetQueueStatus(),
+		"udpBlocked": tunnel.UDPBlocked(),
+	})
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/manager"
+	"github.com/xjasonlyu/tun2socks/internal/proxy"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+func proxyRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Get("/", getProxies)
+	r.Put("/{name}", selectProxy)
+	return r
+}
+
+func getProxies(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, render.M{
+		"selected": proxy.Selected(),
+		"proxies":  proxy.Outbounds(),
+	})
+}
+
+func selectProxy(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if err := proxy.Select(name); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	log.Infof("[API] switch proxy to %s", name)
+	closeProxyConnections(r)
+	render.NoContent(w, r)
+}
+
+func updateProxy(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		URL string `json:"url"`
+	}{}
+	if err := render.DecodeJSON(r.Body, &req); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, ErrBadRequest)
+		return
+	}
+
+	if err := proxy.Register(req.URL); err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, newError(err.Error()))
+		return
+	}
+
+	log.Infof("[API] switch proxy to %s://%s", proxy.Type(), proxy.Addr())
+	closeProxyConnections(r)
+	render.NoContent(w, r)
+}
+
+// closeProxyConnections closes connections through PROXY
+// if `close` is set in query.
+func closeProxyConnections(r *http.Request) {
+	if v, _ := strconv.ParseBool(r.URL.Query().Get("close")); !v {
+		return
+	}
+
+	n := manager.DefaultManager.CloseIf(manager.ReasonAPI, func(m *adapter.Metadata) bool {
+		return m.Outbound == proxy.OutboundProxy
+	})
+	log.Infof("[API] %d connections through old proxy closed", n)
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net"
@@ -17,6 +18,7 @@ import (
 	"github.com/urfave/cli/v2"
 
 	"github.com/xjasonlyu/tun2socks/internal/manager"
+	"github.com/xjasonlyu/tun2socks/internal/tunnel"
 	"github.com/xjasonlyu/tun2socks/pkg/log"
 )
 
@@ -27,6 +29,8 @@ var (
 	serverSecret = ""
 	serverAddr   = ""
 
+	server *http.Server
+
 	upgrader = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true
@@ -66,10 +70,19 @@ func Start(apiURL string, app *cli.App) error {
 		r.Use(authentication)
 
 		r.Get("/", hello)
+		r.Get("/dns-leak", getDNSLeak)
+		r.Get("/hooks", getHooks)
+		r.Get("/limits", getLimits)
 		r.Get("/logs", getLogs)
+		r.Get("/queues", getQueues)
 		r.Get("/traffic", traffic)
 		r.Get("/version", version)
+		r.Mount("/bandwidth", bandwidthRouter())
 		r.Mount("/connections", connectionRouter())
+		r.Mount("/dnat", dnatRouter())
+		r.Mount("/clients", clientRouter())
+		r.Mount("/proxies", proxyRouter())
+		r.Put("/proxy", updateProxy)
 	})
 
 	tcpAddr, err := net.ResolveTCPAddr("tcp", serverAddr)
@@ -82,13 +95,22 @@ func Start(apiURL string, app *cli.App) error {
 		return err
 	}
 
+	server = &http.Server{Handler: r}
 	go func() {
-		_ = http.Serve(listener, r)
+		_ = server.Serve(listener)
 	}()
 
 	return nil
 }
 
+// Stop gracefully shuts down the API server with ctx.
+func Stop(ctx context.Context) error {
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
 func authentication(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		if serverSecret == "" {
@@ -179,7 +201,14 @@ func getLogs(w http.ResponseWriter, r *http.Request) {
 }
 
 func hello(w http.ResponseWriter, r *http.Request) {
-	render.JSON(w, r, render.M{"hello": serverApp.Name})
+	resp := render.M{"hello": serverApp.Name, "status": "ok"}
+	if ks := tunnel.GetKillSwitchStatus(); ks.Enabled {
+		if ks.Engaged {
+			resp["status"] = "degraded"
+		}
+		resp["killSwitch"] = ks
+	}
+	render.JSON(w, r, resp)
 }
 
 type Traffic struct {
@@ -1,22 +1,28 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/urfave/cli/v2"
 
 	"github.com/xjasonlyu/clash/component/dialer"
+	"github.com/xjasonlyu/tun2socks/internal/accesslog"
 	"github.com/xjasonlyu/tun2socks/internal/api"
 	"github.com/xjasonlyu/tun2socks/internal/core"
 	"github.com/xjasonlyu/tun2socks/internal/dns"
+	"github.com/xjasonlyu/tun2socks/internal/manager"
 	"github.com/xjasonlyu/tun2socks/internal/proxy"
+	"github.com/xjasonlyu/tun2socks/internal/rule"
 	"github.com/xjasonlyu/tun2socks/internal/tunnel"
 	"github.com/xjasonlyu/tun2socks/pkg/log"
+	"github.com/xjasonlyu/tun2socks/pkg/nat"
 	"github.com/xjasonlyu/tun2socks/pkg/tun"
 )
 
@@ -36,6 +42,70 @@ func printVersion(app *cli.App) {
 	)
 }
 
+func setTimeouts(c *cli.Context) error {
+	if err := core.SetTCPKeepalive(c.Duration("tcp-keepalive-idle"), c.Duration("tcp-keepalive-interval")); err != nil {
+		return fmt.Errorf("set TCP keepalive: %w", err)
+	}
+	if err := tunnel.SetTCPConnectTimeout(c.Duration("tcp-connect-timeout")); err != nil {
+		return fmt.Errorf("set TCP connect timeout: %w", err)
+	}
+	if err := tunnel.SetEarlyDataWait(c.Duration("tcp-early-data")); err != nil {
+		return fmt.Errorf("set TCP early data: %w", err)
+	}
+	if err := tunnel.SetTCPWaitTimeout(c.Duration("tcp-wait-timeout")); err != nil {
+		return fmt.Errorf("set TCP wait timeout: %w", err)
+	}
+	if err := tunnel.SetUDPTimeout(c.Duration("udp-timeout")); err != nil {
+		return fmt.Errorf("set UDP timeout: %w", err)
+	}
+	if err := tunnel.SetTimeoutOverrides(c.StringSlice("port-timeout")); err != nil {
+		return fmt.Errorf("set port timeout: %w", err)
+	}
+
+	idle := c.Duration("tcp-idle-timeout")
+	if idle != 0 && idle < time.Second {
+		return fmt.Errorf("TCP idle timeout %s must be 0 or at least 1s", idle)
+	}
+	manager.DefaultManager.SetIdleTimeout(idle)
+	return nil
+}
+
+func setBandwidth(c *cli.Context) error {
+	if c.IsSet("bandwidth") {
+		bw, err := manager.ParseBandwidth(c.String("bandwidth"))
+		if err != nil {
+			return err
+		}
+		manager.DefaultManager.SetBandwidth(bw)
+	}
+
+	if c.IsSet("bandwidth-per-source") {
+		bw, err := manager.ParseBandwidth(c.String("bandwidth-per-source"))
+		if err != nil {
+			return err
+		}
+		manager.DefaultManager.SetSourceBandwidth(bw)
+	}
+
+	for _, raw := range c.StringSlice("bandwidth-outbound") {
+		kv := strings.SplitN(raw, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid outbound bandwidth: %s", raw)
+		}
+
+		if _, ok := proxy.Lookup(kv[0]); !ok {
+			return fmt.Errorf("outbound %s not found", kv[0])
+		}
+
+		bw, err := manager.ParseBandwidth(kv[1])
+		if err != nil {
+			return err
+		}
+		manager.DefaultManager.SetOutboundBandwidth(kv[0], bw)
+	}
+	return nil
+}
+
 func Main(c *cli.Context) error {
 	if c.Bool("version") {
 		printVersion(c.App)
@@ -54,6 +124,15 @@ func Main(c *cli.Context) error {
 		log.Infof("[DIALER] bind to interface: %s", name)
 	}
 
+	if c.IsSet("access-log") {
+		path := c.String("access-log")
+		maxSize := int64(c.Int("access-log-max-size")) << 20
+		if err := accesslog.Open(path, maxSize, c.Int("access-log-backups")); err != nil {
+			return fmt.Errorf("open access log %s: %w", path, err)
+		}
+		log.Infof("[ACCESS] write access log to: %s", path)
+	}
+
 	if c.IsSet("api") { /* initiate API */
 		raw := c.String("api")
 		if err := api.Start(raw, c.App); err != nil {
@@ -70,19 +149,148 @@ func Main(c *cli.Context) error {
 		log.Infof("[DNS] listen and serve at: %s", raw)
 	}
 
+	proxyURL := c.String("proxy")
+	if err := proxy.Register(proxyURL); err != nil {
+		return fmt.Errorf("register proxy %s: %w", proxyURL, err)
+	}
+
+	if c.IsSet("outbound") {
+		if err := proxy.RegisterOutbounds(c.StringSlice("outbound")); err != nil {
+			return fmt.Errorf("register outbound: %w", err)
+		}
+	}
+
+	proxy.SetBreaker(c.Int("breaker-threshold"), c.Duration("breaker-backoff"))
+	if err := proxy.SetFallback(c.String("fallback")); err != nil {
+		return fmt.Errorf("set fallback: %w", err)
+	}
+
+	if c.IsSet("rule-set") {
+		if err := rule.RegisterRuleSets(c.StringSlice("rule-set")); err != nil {
+			return fmt.Errorf("register rule-set: %w", err)
+		}
+	}
+
+	if c.IsSet("rule") {
+		if err := rule.Register(c.StringSlice("rule")); err != nil {
+			return fmt.Errorf("register rule: %w", err)
+		}
+	}
+
+	if c.IsSet("client") {
+		if err := rule.RegisterClients(c.StringSlice("client")); err != nil {
+			return fmt.Errorf("register client: %w", err)
+		}
+	}
+
+	if c.IsSet("dnat") {
+		var rules []*tunnel.DNATRule
+		for _, raw := range c.StringSlice("dnat") {
+			r, err := tunnel.ParseDNATRule(raw)
+			if err != nil {
+				return err
+			}
+			if r.Outbound != "" {
+				if _, ok := proxy.Lookup(r.Outbound); !ok {
+					return fmt.Errorf("outbound %s of DNAT rule not found", r.Outbound)
+				}
+			}
+			rules = append(rules, r)
+		}
+		tunnel.SetDNATRules(rules)
+	}
+
+	if c.IsSet("dns-hijack") {
+		if !dns.Running() {
+			return errors.New("DNS hijack requires fake DNS server")
+		}
+		if err := tunnel.SetDNSHijack(c.String("dns-hijack")); err != nil {
+			return fmt.Errorf("set DNS hijack: %w", err)
+		}
+	}
+
+	if c.IsSet("dns-leak") {
+		if c.String("dns-leak") == tunnel.DNSLeakRedirect && !dns.Running() {
+			return errors.New("DNS leak redirect requires fake DNS server")
+		}
+		if err := tunnel.SetDNSLeakPolicy(c.String("dns-leak"), c.String("dns-leak-doh-ips"), c.String("dns-leak-doh-domains")); err != nil {
+			return fmt.Errorf("set DNS leak policy: %w", err)
+		}
+	}
+
+	if c.IsSet("sniff-ports") {
+		if err := tunnel.SetSniffPorts(c.String("sniff-ports")); err != nil {
+			return fmt.Errorf("set sniff ports: %w", err)
+		}
+	}
+
+	if c.IsSet("udp-block-ports") || c.IsSet("udp-block-domains") {
+		if err := tunnel.SetUDPBlock(c.String("udp-block-ports"), c.String("udp-block-domains")); err != nil {
+			return fmt.Errorf("set UDP block: %w", err)
+		}
+	}
+
+	if err := setTimeouts(c); err != nil {
+		return err
+	}
+
+	if err := setBandwidth(c); err != nil {
+		return fmt.Errorf("set bandwidth: %w", err)
+	}
+
+	if err := tunnel.SetLimits(tunnel.Limits{
+		Total:          c.Int("max-connections"),
+		PerSource:      c.Int("max-connections-per-source"),
+		PerDestination: c.Int("max-connections-per-destination"),
+	}); err != nil {
+		return fmt.Errorf("set limits: %w", err)
+	}
+
+	natMode, err := nat.ParseMode(c.String("udp-nat"))
+	if err != nil {
+		return err
+	}
+	tunnel.SetUDPNATMode(natMode)
+
+	if c.Bool("reject-ipv6") {
+		core.SetRejectIPv6(true)
+	}
+
+	if c.Bool("tcp-defer-handshake") {
+		/* sniffing needs the first bytes sent after handshake */
+		if c.String("sniff-ports") != "" || (c.String("dns-leak") != "" && c.String("dns-leak-doh-domains") != "") {
+			log.Warnf("[TCP] handshake isn't deferred on sniffed ports")
+		}
+		core.SetTCPDeferHandshake(true)
+	}
+
+	if c.Bool("find-process") {
+		tunnel.SetFindProcess(true)
+	}
+
+	if c.Bool("kill-switch") {
+		if fallback, ok := proxy.Lookup(c.String("fallback")); ok && fallback.Type() == "direct" {
+			return errors.New("kill switch doesn't allow direct fallback")
+		}
+		if err := tunnel.StartKillSwitch(c.String("health-check-url"), c.Duration("health-check-interval")); err != nil {
+			return fmt.Errorf("start kill switch: %w", err)
+		}
+		log.Infof("[KILL] check health by: %s", c.String("health-check-url"))
+	}
+
+	if err := tunnel.Start(c.Int("udp-workers"), c.Int("udp-queue-size")); err != nil {
+		return fmt.Errorf("start tunnel: %w", err)
+	}
+
 	deviceURL := c.String("device")
 	device, err := tun.Open(deviceURL)
 	if err != nil {
 		return fmt.Errorf("open device %s: %w", deviceURL, err)
 	}
-	defer device.Close()
 
-	proxyURL := c.String("proxy")
-	if err := proxy.Register(proxyURL); err != nil {
-		return fmt.Errorf("register proxy %s: %w", proxyURL, err)
-	}
-
-	if _, err := core.NewDefaultStack(device, tunnel.Add, tunnel.AddPacket); err != nil {
+	s, err := core.NewDefaultStack(device, tunnel.Add, tunnel.AddPacket)
+	if err != nil {
+		device.Close()
 		return fmt.Errorf("initiate stack: %w", err)
 	}
 	log.Infof("[STACK] %s --> %s", deviceURL, proxyURL)
@@ -91,5 +299,6 @@ func Main(c *cli.Context) error {
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	<-sigCh
 
+	shutdown(s, device, c.Duration("drain-timeout"), sigCh)
 	return nil
 }
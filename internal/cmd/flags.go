@@ -1,24 +1,133 @@
 package cmd
 
-import "github.com/urfave/cli/v2"
+import (
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/xjasonlyu/tun2socks/internal/tunnel"
+)
 
 var (
+	AccessLog = cli.StringFlag{
+		Name:  "access-log",
+		Usage: "File to write access log of connections, stdout for standard output",
+	}
+
+	AccessLogBackups = cli.IntFlag{
+		Name:  "access-log-backups",
+		Usage: "Number of rotated access log files to keep",
+		Value: 3,
+	}
+
+	AccessLogMaxSize = cli.IntFlag{
+		Name:  "access-log-max-size",
+		Usage: "Max size in MB of access log file before rotation, 0 to disable",
+		Value: 100,
+	}
+
 	API = cli.StringFlag{
 		Name:  "api",
 		Usage: "URL of external API to listen",
 	}
 
+	Bandwidth = cli.StringFlag{
+		Name:  "bandwidth",
+		Usage: "Global bandwidth limit in bytes/s, e.g. 1M/10M for UP/DOWN",
+	}
+
+	BandwidthOutbound = cli.StringSliceFlag{
+		Name:  "bandwidth-outbound",
+		Usage: "Bandwidth limit of outbound, e.g. name=1M/10M",
+	}
+
+	BandwidthPerSource = cli.StringFlag{
+		Name:  "bandwidth-per-source",
+		Usage: "Bandwidth limit of each source IP, e.g. 1M/10M",
+	}
+
+	BreakerBackoff = cli.DurationFlag{
+		Name:  "breaker-backoff",
+		Usage: "Initial backoff of open circuit breaker",
+		Value: 5 * time.Second,
+	}
+
+	BreakerThreshold = cli.IntFlag{
+		Name:  "breaker-threshold",
+		Usage: "Consecutive dial failures to open circuit breaker, 0 to disable",
+	}
+
+	Client = cli.StringSliceFlag{
+		Name:  "client",
+		Usage: "Outbound of client, e.g. 192.168.1.0/24=name",
+	}
+
 	Device = cli.StringFlag{
 		Name:    "device",
 		Aliases: []string{"d"},
 		Usage:   "URL of device to open",
 	}
 
+	DNAT = cli.StringSliceFlag{
+		Name:  "dnat",
+		Usage: "Rewrite destination, e.g. 10.0.0.5:5432=127.0.0.1:15432,DIRECT",
+	}
+
 	DNS = cli.StringFlag{
 		Name:  "dns",
 		Usage: "URL of fake DNS to listen",
 	}
 
+	DNSHijack = cli.StringFlag{
+		Name:  "dns-hijack",
+		Usage: "DNS to answer by fake DNS, e.g. udp://0.0.0.0:53,tcp://any:53",
+	}
+
+	DNSLeak = cli.StringFlag{
+		Name:  "dns-leak",
+		Usage: "Policy of DNS bypassing fake DNS, block or redirect",
+	}
+
+	DNSLeakDoHDomains = cli.StringFlag{
+		Name:  "dns-leak-doh-domains",
+		Usage: "Domains of DoH servers to detect by DNS leak policy",
+		Value: tunnel.DefaultDoHDomains,
+	}
+
+	DNSLeakDoHIPs = cli.StringFlag{
+		Name:  "dns-leak-doh-ips",
+		Usage: "IPs of DoH servers to detect by DNS leak policy",
+		Value: tunnel.DefaultDoHIPs,
+	}
+
+	DrainTimeout = cli.DurationFlag{
+		Name:  "drain-timeout",
+		Usage: "Time to wait for active TCP connections on exit, UDP sessions are closed without waiting",
+		Value: 10 * time.Second,
+	}
+
+	Fallback = cli.StringFlag{
+		Name:  "fallback",
+		Usage: "Outbound to dial while circuit breaker is open",
+	}
+
+	FindProcess = cli.BoolFlag{
+		Name:  "find-process",
+		Usage: "Find process of local connections (Linux only)",
+	}
+
+	HealthCheckInterval = cli.DurationFlag{
+		Name:  "health-check-interval",
+		Usage: "Interval of proxy health checks of kill switch",
+		Value: tunnel.DefaultHealthCheckInterval,
+	}
+
+	HealthCheckURL = cli.StringFlag{
+		Name:  "health-check-url",
+		Usage: "URL to request through proxy for health checks of kill switch",
+		Value: tunnel.DefaultHealthCheckURL,
+	}
+
 	Hosts = cli.StringSliceFlag{
 		Name:  "hosts",
 		Usage: "Extra hosts mapping",
@@ -30,6 +139,11 @@ var (
 		Usage:   "Bind interface to dial",
 	}
 
+	KillSwitch = cli.BoolFlag{
+		Name:  "kill-switch",
+		Usage: "Reject all flows and pause DNS while proxy is unhealthy",
+	}
+
 	LogLevel = cli.StringFlag{
 		Name:    "loglevel",
 		Aliases: []string{"l"},
@@ -37,12 +151,128 @@ var (
 		Value:   "INFO",
 	}
 
+	MaxConnections = cli.IntFlag{
+		Name:  "max-connections",
+		Usage: "Max concurrent connections, 0 for unlimited",
+	}
+
+	MaxConnectionsPerDestination = cli.IntFlag{
+		Name:  "max-connections-per-destination",
+		Usage: "Max concurrent connections to a destination, 0 for unlimited",
+	}
+
+	MaxConnectionsPerSource = cli.IntFlag{
+		Name:  "max-connections-per-source",
+		Usage: "Max concurrent connections from a source IP, 0 for unlimited",
+	}
+
+	Outbound = cli.StringSliceFlag{
+		Name:  "outbound",
+		Usage: "Named outbound to dial, e.g. name=URL",
+	}
+
+	PortTimeout = cli.StringSliceFlag{
+		Name:  "port-timeout",
+		Usage: "Timeout of destination port, e.g. udp/3478=10m",
+	}
+
 	Proxy = cli.StringFlag{
 		Name:    "proxy",
 		Aliases: []string{"p"},
 		Usage:   "URL of proxy to dial",
 	}
 
+	RejectIPv6 = cli.BoolFlag{
+		Name:  "reject-ipv6",
+		Usage: "Reject IPv6 destinations by TCP RST and ICMPv6 unreachable, ipv6=false of DNS URL drops AAAA",
+	}
+
+	Rule = cli.StringSliceFlag{
+		Name:  "rule",
+		Usage: "Routing rule, e.g. RULE-SET,name,DIRECT",
+	}
+
+	RuleSet = cli.StringSliceFlag{
+		Name:  "rule-set",
+		Usage: "Rule-set file to load, e.g. name=path",
+	}
+
+	SniffPorts = cli.StringFlag{
+		Name:  "sniff-ports",
+		Usage: "Destination ports to sniff domain, e.g. 80,443",
+	}
+
+	TCPConnectTimeout = cli.DurationFlag{
+		Name:  "tcp-connect-timeout",
+		Usage: "Timeout of dialing TCP",
+		Value: 5 * time.Second,
+	}
+
+	TCPDeferHandshake = cli.BoolFlag{
+		Name:  "tcp-defer-handshake",
+		Usage: "Complete TCP handshake after dialing upstream, reset if dial fails, except on sniffed ports",
+	}
+
+	TCPEarlyData = cli.DurationFlag{
+		Name:  "tcp-early-data",
+		Usage: "Time to wait for first client bytes to send with proxy handshake, 0 to disable",
+	}
+
+	TCPIdleTimeout = cli.DurationFlag{
+		Name:  "tcp-idle-timeout",
+		Usage: "Close TCP connection idle for the duration, 0 to disable",
+	}
+
+	TCPKeepaliveIdle = cli.DurationFlag{
+		Name:  "tcp-keepalive-idle",
+		Usage: "Idle time before sending TCP keepalive",
+		Value: 60 * time.Second,
+	}
+
+	TCPKeepaliveInterval = cli.DurationFlag{
+		Name:  "tcp-keepalive-interval",
+		Usage: "Interval between TCP keepalive probes",
+		Value: 30 * time.Second,
+	}
+
+	TCPWaitTimeout = cli.DurationFlag{
+		Name:  "tcp-wait-timeout",
+		Usage: "Idle timeout of the other side of half-closed TCP",
+		Value: 5 * time.Second,
+	}
+
+	UDPBlockDomains = cli.StringFlag{
+		Name:  "udp-block-domains",
+		Usage: "Domains to reject UDP with ICMP port unreachable, e.g. example.com",
+	}
+
+	UDPBlockPorts = cli.StringFlag{
+		Name:  "udp-block-ports",
+		Usage: "Destination ports to reject UDP with ICMP port unreachable, e.g. 443",
+	}
+
+	UDPNAT = cli.StringFlag{
+		Name:  "udp-nat",
+		Usage: "NAT mode of UDP: fullcone, restricted, port-restricted or symmetric",
+		Value: "fullcone",
+	}
+
+	UDPQueueSize = cli.IntFlag{
+		Name:  "udp-queue-size",
+		Usage: "Packets buffered by each UDP queue, 0 for default",
+	}
+
+	UDPTimeout = cli.DurationFlag{
+		Name:  "udp-timeout",
+		Usage: "Idle timeout of UDP session",
+		Value: 30 * time.Second,
+	}
+
+	UDPWorkers = cli.IntFlag{
+		Name:  "udp-workers",
+		Usage: "Number of UDP workers, 0 for default",
+	}
+
 	Version = cli.BoolFlag{
 		Name:    "version",
 		Aliases: []string{"v"},
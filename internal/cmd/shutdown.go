@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+
+	"github.com/xjasonlyu/tun2socks/internal/accesslog"
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/api"
+	"github.com/xjasonlyu/tun2socks/internal/core"
+	"github.com/xjasonlyu/tun2socks/internal/dns"
+	"github.com/xjasonlyu/tun2socks/internal/manager"
+	"github.com/xjasonlyu/tun2socks/internal/rule"
+	"github.com/xjasonlyu/tun2socks/internal/tunnel"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+	"github.com/xjasonlyu/tun2socks/pkg/tun"
+)
+
+const (
+	drainInterval  = 100 * time.Millisecond
+	apiStopTimeout = 5 * time.Second
+)
+
+// shutdown stops accepting new flows and health checks of kill
+// switch, gives active TCP relays drainTimeout to finish, then
+// closes the remaining flows, the stack, the device, rule-set
+// watchers, the DNS server, the API server and the access log
+// in order. UDP sessions aren't drained, as they only end by
+// idle timeout.
+// Another signal from sigCh skips the drain period.
+func shutdown(s *stack.Stack, device tun.Device, drainTimeout time.Duration, sigCh <-chan os.Signal) {
+	core.StopAccepting()
+	tunnel.StopAccepting()
+	tunnel.StopKillSwitch()
+
+	m := manager.DefaultManager
+	active := m.Count(adapter.TCP)
+	log.Infof("[STACK] shutting down, draining %d TCP connections in %s", active, drainTimeout)
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+
+drain:
+	for m.Count(adapter.TCP) > 0 {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			break drain
+		case <-sigCh:
+			log.Warnf("[STACK] drain interrupted")
+			break drain
+		}
+	}
+
+	remaining := m.Count(adapter.TCP)
+	closed := m.CloseAll(manager.ReasonShutdown)
+
+	s.Close()
+	if err := device.Close(); err != nil {
+		log.Warnf("[STACK] close device error: %v", err)
+	}
+	// link endpoint stops dispatching after device is closed.
+	s.Wait()
+
+	rule.CloseRuleSets()
+
+	if err := dns.Stop(); err != nil {
+		log.Warnf("[DNS] stop error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), apiStopTimeout)
+	defer cancel()
+	if err := api.Stop(ctx); err != nil {
+		log.Warnf("[API] stop error: %v", err)
+	}
+
+	// flows closed above are logged as their relays return,
+	// which is done once the stack is closed.
+	if err := accesslog.Close(); err != nil {
+		log.Warnf("[ACCESS] close error: %v", err)
+	}
+
+	drained := active - remaining
+	if drained < 0 {
+		drained = 0
+	}
+	log.Infof("[STACK] shutdown: %d TCP connections drained, %d flows closed", drained, closed)
+}
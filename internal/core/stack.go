@@ -1,6 +1,9 @@
 package core
 
 import (
+	"go.uber.org/atomic"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
@@ -9,6 +12,33 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 )
 
+var (
+	// _accepting reports whether forwarders accept new flows.
+	_accepting = atomic.NewBool(true)
+
+	// _rejectIPv6 makes forwarders reject flows to IPv6
+	// destinations immediately.
+	_rejectIPv6 = atomic.NewBool(false)
+)
+
+// StopAccepting makes TCP forwarder reset new connections,
+// connections already accepted are not affected.
+func StopAccepting() {
+	_accepting.Store(false)
+}
+
+// SetRejectIPv6 enables or disables rejecting IPv6 flows, TCP
+// is reset and UDP is replied with ICMPv6 port unreachable, so
+// dual-stack clients fall back to IPv4 without waiting.
+func SetRejectIPv6(v bool) {
+	_rejectIPv6.Store(v)
+}
+
+// rejectIPv6 reports whether flows to addr should be rejected.
+func rejectIPv6(addr tcpip.Address) bool {
+	return len(addr) == header.IPv6AddressSize && _rejectIPv6.Load()
+}
+
 // NewStack returns *stack.Stack with provided options.
 func NewStack(opts ...Option) (*stack.Stack, error) {
 	ipstack := stack.New(stack.Options{
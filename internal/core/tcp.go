@@ -1,10 +1,13 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
+	"go.uber.org/atomic"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
@@ -35,35 +38,73 @@ const (
 	tcpKeepaliveInterval = 30 * time.Second
 )
 
+var (
+	errRejected       = errors.New("connection rejected")
+	errNotEstablished = errors.New("connection not established")
+)
+
+var (
+	_tcpKeepaliveIdle     = atomic.NewDuration(tcpKeepaliveIdle)
+	_tcpKeepaliveInterval = atomic.NewDuration(tcpKeepaliveInterval)
+
+	// _tcpDeferHandshake holds SYN of new connections until
+	// the tunnel establishes them, see pendingConn.
+	_tcpDeferHandshake = atomic.NewBool(false)
+)
+
+// SetTCPKeepalive sets keepalive idle and interval time of
+// TCP connections accepted afterwards.
+func SetTCPKeepalive(idle, interval time.Duration) error {
+	if idle < time.Second || interval < time.Second {
+		return fmt.Errorf("keepalive idle %s and interval %s must be at least 1s", idle, interval)
+	}
+	_tcpKeepaliveIdle.Store(idle)
+	_tcpKeepaliveInterval.Store(interval)
+	return nil
+}
+
+// SetTCPDeferHandshake enables or disables deferring handshake
+// of new connections until the upstream is dialed, so clients
+// get a reset instead of a connected then reset connection if
+// the dial fails. Connections to sniffed ports, including 443
+// with DoH domains of DNS leak policy, are still established
+// before dialing, as sniffing needs the first bytes of client.
+func SetTCPDeferHandshake(v bool) {
+	_tcpDeferHandshake.Store(v)
+}
+
 type tcpHandleFunc func(adapter.TCPConn)
 
 func WithTCPHandler(handle tcpHandleFunc) Option {
 	return func(s *stack.Stack) error {
 		tcpForwarder := tcp.NewForwarder(s, defaultWndSize, maxConnAttempts, func(r *tcp.ForwarderRequest) {
-			var wq waiter.Queue
 			id := r.ID()
-			ep, err := r.CreateEndpoint(&wq)
-			if err != nil {
-				log.Warnf("[STACK] %s create endpoint error: %v", formatID(&id), err)
-				// prevent potential half-open TCP connection leak.
+			if !_accepting.Load() || rejectIPv6(id.LocalAddress) {
 				r.Complete(true)
 				return
 			}
-			r.Complete(false)
 
-			if err := setKeepalive(ep); err != nil {
-				log.Warnf("[STACK] %s %v", formatID(&id), err)
+			metadata := &adapter.Metadata{
+				Net:     adapter.TCP,
+				SrcIP:   net.IP(id.RemoteAddress),
+				SrcPort: id.RemotePort,
+				DstIP:   net.IP(id.LocalAddress),
+				DstPort: id.LocalPort,
+				Timeout: adapter.Timeout{
+					KeepaliveIdle:     _tcpKeepaliveIdle.Load(),
+					KeepaliveInterval: _tcpKeepaliveInterval.Load(),
+				},
 			}
 
-			conn := &tcpConn{
-				Conn: gonet.NewTCPConn(&wq, ep),
-				metadata: &adapter.Metadata{
-					Net:     adapter.TCP,
-					SrcIP:   net.IP(id.RemoteAddress),
-					SrcPort: id.RemotePort,
-					DstIP:   net.IP(id.LocalAddress),
-					DstPort: id.LocalPort,
-				},
+			if _tcpDeferHandshake.Load() {
+				handle(newPendingConn(r, metadata))
+				return
+			}
+
+			conn, err := accept(r, metadata)
+			if err != nil {
+				log.Warnf("[STACK] %s %v", formatID(&id), err)
+				return
 			}
 
 			handle(conn)
@@ -73,6 +114,30 @@ func WithTCPHandler(handle tcpHandleFunc) Option {
 	}
 }
 
+// accept completes the handshake of r and returns the
+// established connection.
+func accept(r *tcp.ForwarderRequest, metadata *adapter.Metadata) (*tcpConn, error) {
+	var wq waiter.Queue
+	id := r.ID() /* invalid after completed */
+	ep, err := r.CreateEndpoint(&wq)
+	if err != nil {
+		// prevent potential half-open TCP connection leak.
+		r.Complete(true)
+		return nil, fmt.Errorf("create endpoint: %s", err)
+	}
+	r.Complete(false)
+
+	if err := setKeepalive(ep, metadata.Timeout.KeepaliveIdle, metadata.Timeout.KeepaliveInterval); err != nil {
+		log.Warnf("[STACK] %s %v", formatID(&id), err)
+	}
+
+	return &tcpConn{
+		TCPConn:  gonet.NewTCPConn(&wq, ep),
+		ep:       ep,
+		metadata: metadata,
+	}, nil
+}
+
 func formatID(id *stack.TransportEndpointID) string {
 	return fmt.Sprintf(
 		"%s:%d --> %s:%d",
@@ -83,15 +148,15 @@ func formatID(id *stack.TransportEndpointID) string {
 	)
 }
 
-func setKeepalive(ep tcpip.Endpoint) error {
+func setKeepalive(ep tcpip.Endpoint, idle, interval time.Duration) error {
 	if err := ep.SetSockOptBool(tcpip.KeepaliveEnabledOption, true); err != nil {
 		return fmt.Errorf("set keepalive: %s", err)
 	}
-	idleOpt := tcpip.KeepaliveIdleOption(tcpKeepaliveIdle)
+	idleOpt := tcpip.KeepaliveIdleOption(idle)
 	if err := ep.SetSockOpt(&idleOpt); err != nil {
 		return fmt.Errorf("set keepalive idle: %s", err)
 	}
-	intervalOpt := tcpip.KeepaliveIntervalOption(tcpKeepaliveInterval)
+	intervalOpt := tcpip.KeepaliveIntervalOption(interval)
 	if err := ep.SetSockOpt(&intervalOpt); err != nil {
 		return fmt.Errorf("set keepalive interval: %s", err)
 	}
@@ -99,10 +164,158 @@ func setKeepalive(ep tcpip.Endpoint) error {
 }
 
 type tcpConn struct {
-	net.Conn
+	*gonet.TCPConn
+	ep       tcpip.Endpoint
 	metadata *adapter.Metadata
 }
 
+// Abort resets the connection by sending RST.
+func (c *tcpConn) Abort() {
+	c.ep.Abort()
+}
+
 func (c *tcpConn) Metadata() *adapter.Metadata {
 	return c.metadata
 }
+
+// pendingConn is a TCP connection whose SYN is held until
+// Establish is called, it's reset if closed or aborted before.
+// Its I/O fails with errNotEstablished before established.
+type pendingConn struct {
+	r        *tcp.ForwarderRequest
+	metadata *adapter.Metadata
+
+	laddr net.Addr
+	raddr net.Addr
+
+	mu   sync.Mutex
+	done bool
+	conn *tcpConn
+	err  error
+}
+
+func newPendingConn(r *tcp.ForwarderRequest, metadata *adapter.Metadata) *pendingConn {
+	return &pendingConn{
+		r:        r,
+		metadata: metadata,
+		laddr:    &net.TCPAddr{IP: metadata.DstIP, Port: int(metadata.DstPort)},
+		raddr:    &net.TCPAddr{IP: metadata.SrcIP, Port: int(metadata.SrcPort)},
+	}
+}
+
+// Establish completes the handshake with the client.
+func (c *pendingConn) Establish() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.done {
+		c.done = true
+		c.conn, c.err = accept(c.r, c.metadata)
+	}
+	return c.err
+}
+
+// established returns the connection if the handshake is
+// completed, or the reason it's not.
+func (c *pendingConn) established() (*tcpConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.conn != nil:
+		return c.conn, nil
+	case c.err != nil:
+		return nil, c.err
+	default:
+		return nil, errNotEstablished
+	}
+}
+
+// reject answers the held SYN with RST, it returns the
+// connection if the handshake is already completed.
+func (c *pendingConn) reject() *tcpConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.done {
+		c.done = true
+		c.r.Complete(true)
+		c.err = errRejected
+	}
+	return c.conn
+}
+
+// Abort resets the connection by sending RST.
+func (c *pendingConn) Abort() {
+	if conn := c.reject(); conn != nil {
+		conn.Abort()
+	}
+}
+
+func (c *pendingConn) Close() error {
+	if conn := c.reject(); conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+// CloseWrite half-closes the established connection.
+func (c *pendingConn) CloseWrite() error {
+	conn, err := c.established()
+	if err != nil {
+		return err
+	}
+	return conn.CloseWrite()
+}
+
+func (c *pendingConn) Read(b []byte) (int, error) {
+	conn, err := c.established()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Read(b)
+}
+
+func (c *pendingConn) Write(b []byte) (int, error) {
+	conn, err := c.established()
+	if err != nil {
+		return 0, err
+	}
+	return conn.Write(b)
+}
+
+func (c *pendingConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *pendingConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *pendingConn) SetDeadline(t time.Time) error {
+	conn, err := c.established()
+	if err != nil {
+		return err
+	}
+	return conn.SetDeadline(t)
+}
+
+func (c *pendingConn) SetReadDeadline(t time.Time) error {
+	conn, err := c.established()
+	if err != nil {
+		return err
+	}
+	return conn.SetReadDeadline(t)
+}
+
+func (c *pendingConn) SetWriteDeadline(t time.Time) error {
+	conn, err := c.established()
+	if err != nil {
+		return err
+	}
+	return conn.SetWriteDeadline(t)
+}
+
+func (c *pendingConn) Metadata() *adapter.Metadata {
+	return c.metadata
+}
@@ -17,7 +17,9 @@ import (
 
 const udpNoChecksum = true
 
-type udpHandleFunc func(adapter.UDPPacket)
+// udpHandleFunc handles UDP packet, it returns false if the
+// packet is rejected, then ICMP port unreachable is replied.
+type udpHandleFunc func(adapter.UDPPacket) bool
 
 func WithUDPHandler(handle udpHandleFunc) Option {
 	return func(s *stack.Stack) error {
@@ -38,6 +40,11 @@ func WithUDPHandler(handle udpHandleFunc) Option {
 
 			s.Stats().UDP.PacketsReceived.Increment()
 
+			// ICMPv6 port unreachable is replied by stack.
+			if rejectIPv6(id.LocalAddress) {
+				return false
+			}
+
 			netHdr := pkt.Network()
 			route, err := s.FindRoute(pkt.NICID, netHdr.DestinationAddress(), netHdr.SourceAddress(), pkt.NetworkProtocolNumber, false /* multicastLoop */)
 			if err != nil {
@@ -59,7 +66,10 @@ func WithUDPHandler(handle udpHandleFunc) Option {
 				payload: pkt.Data.ToView(),
 			}
 
-			handle(packet)
+			if !handle(packet) {
+				packet.Drop()
+				return false
+			}
 			return true
 		}
 		s.SetTransportProtocolHandler(udp.ProtocolNumber, udpHandlePacket)
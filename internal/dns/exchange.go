@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	D "github.com/miekg/dns"
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/clash/component/fakeip"
+	"github.com/xjasonlyu/clash/component/trie"
+	"github.com/xjasonlyu/clash/dns"
+)
+
+const dnsDefaultTTL = 600
+
+var (
+	// _handler holds *handler of local DNS server, nil if the
+	// server is stopped or paused.
+	_handler atomic.Value
+
+	// _running reports whether local DNS server is started.
+	_running = atomic.NewBool(false)
+
+	errServerNotStarted = errors.New("DNS server not started")
+)
+
+// handler answers DNS messages like the handler of local DNS
+// server, which isn't exported: by hosts first, then by fake IP,
+// then by nameservers.
+type handler struct {
+	r     *dns.Resolver
+	pool  *fakeip.Pool
+	hosts *trie.DomainTrie
+	ipv6  bool
+}
+
+// Exchange answers raw DNS message by local DNS server in-process
+// and returns the raw reply, it's used to answer hijacked queries.
+// Replies to UDP queries are truncated to the size accepted by
+// the client, replies to TCP queries are not truncated.
+func Exchange(msg []byte, tcp bool) ([]byte, error) {
+	h, _ := _handler.Load().(*handler)
+	if h == nil {
+		return nil, errServerNotStarted
+	}
+
+	r := new(D.Msg)
+	if err := r.Unpack(msg); err != nil {
+		return nil, err
+	}
+
+	reply, err := h.serve(r)
+	if err != nil {
+		reply = new(D.Msg)
+		reply.SetRcode(r, D.RcodeServerFailure)
+	}
+
+	if !tcp {
+		size := D.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		reply.Truncate(size)
+	}
+	return reply.Pack()
+}
+
+// Running reports whether local DNS server is started.
+func Running() bool {
+	return _running.Load()
+}
+
+func (h *handler) serve(r *D.Msg) (*D.Msg, error) {
+	if len(r.Question) == 0 {
+		return nil, errors.New("no question")
+	}
+
+	q := r.Question[0]
+	host := strings.TrimRight(q.Name, ".")
+	if msg := h.serveHosts(r, q, host); msg != nil {
+		return msg, nil
+	}
+
+	if !h.pool.LookupHost(host) {
+		switch q.Qtype {
+		case D.TypeAAAA, D.TypeSVCB, D.TypeHTTPS:
+			return emptyAnswer(r), nil
+		case D.TypeA:
+			rr := &D.A{A: h.pool.Lookup(host)}
+			rr.Hdr = D.RR_Header{Name: q.Name, Rrtype: D.TypeA, Class: D.ClassINET, Ttl: 1}
+			return answer(r, rr), nil
+		}
+	}
+
+	// return an empty AAAA answer when ipv6 disabled.
+	if !h.ipv6 && q.Qtype == D.TypeAAAA {
+		return emptyAnswer(r), nil
+	}
+
+	msg, err := h.r.Exchange(r)
+	if err != nil {
+		return nil, err
+	}
+	msg.SetRcode(r, msg.Rcode)
+	msg.Authoritative = true
+	return msg, nil
+}
+
+func (h *handler) serveHosts(r *D.Msg, q D.Question, host string) *D.Msg {
+	if q.Qclass != D.ClassINET || h.hosts == nil {
+		return nil
+	}
+
+	record := h.hosts.Search(host)
+	if record == nil {
+		return nil
+	}
+
+	ip := record.Data.(net.IP)
+	hdr := D.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: D.ClassINET, Ttl: dnsDefaultTTL}
+	if v4 := ip.To4(); v4 != nil && q.Qtype == D.TypeA {
+		return answer(r, &D.A{Hdr: hdr, A: v4})
+	} else if v6 := ip.To16(); v6 != nil && q.Qtype == D.TypeAAAA {
+		return answer(r, &D.AAAA{Hdr: hdr, AAAA: v6})
+	}
+	return nil
+}
+
+func answer(r *D.Msg, rr D.RR) *D.Msg {
+	msg := r.Copy()
+	msg.Answer = []D.RR{rr}
+	msg.SetRcode(r, D.RcodeSuccess)
+	msg.Authoritative = true
+	msg.RecursionAvailable = true
+	return msg
+}
+
+func emptyAnswer(r *D.Msg) *D.Msg {
+	msg := &D.Msg{Answer: []D.RR{}}
+	msg.SetRcode(r, D.RcodeSuccess)
+	msg.Authoritative = true
+	msg.RecursionAvailable = true
+	return msg
+}
@@ -2,8 +2,11 @@ package dns
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/xjasonlyu/clash/component/resolver"
 	"github.com/xjasonlyu/clash/dns"
@@ -17,6 +20,18 @@ const (
 
 var _defaultNameServer = []string{"223.5.5.5", "8.8.8.8"}
 
+// _server keeps the running DNS server, so that it could be
+// paused and resumed.
+var _server struct {
+	sync.Mutex
+
+	addr   string
+	r      *dns.Resolver
+	m      *dns.ResolverEnhancer
+	h      *handler
+	paused bool
+}
+
 func Start(dnsURL string, rawHosts []string) error {
 	if !strings.Contains(dnsURL, "://") {
 		dnsURL = defaultScheme + "://" + dnsURL
@@ -46,6 +61,13 @@ func Start(dnsURL string, rawHosts []string) error {
 		fakeIPFilter = append(fakeIPFilter, strings.Split(raw, ",")...)
 	}
 
+	ipv6 := true /* answer AAAA by default */
+	if raw := u.Query().Get("ipv6"); raw != "" {
+		if ipv6, err = strconv.ParseBool(raw); err != nil {
+			return fmt.Errorf("invalid ipv6: %s", raw)
+		}
+	}
+
 	pool, err := parseFakeIP(fakeIPRange, fakeIPFilter)
 	if err != nil {
 		return err
@@ -87,7 +109,7 @@ func Start(dnsURL string, rawHosts []string) error {
 	}
 
 	cfg := dns.Config{
-		IPv6:         true,
+		IPv6:         ipv6,
 		Pool:         pool,
 		Hosts:        hosts,
 		Main:         mainNS,
@@ -106,5 +128,58 @@ func Start(dnsURL string, rawHosts []string) error {
 	resolver.DefaultResolver = r
 	resolver.DefaultHostMapper = m
 
-	return dns.ReCreateServer(serverAddr, r, m)
+	_server.Lock()
+	defer _server.Unlock()
+
+	if err := dns.ReCreateServer(serverAddr, r, m); err != nil {
+		return err
+	}
+	_server.addr, _server.r, _server.m, _server.paused = serverAddr, r, m, false
+	_server.h = &handler{r: r, pool: pool, hosts: hosts, ipv6: ipv6}
+
+	_handler.Store(_server.h)
+	_running.Store(true)
+	return nil
+}
+
+// Stop shuts down the DNS server.
+func Stop() error {
+	_server.Lock()
+	defer _server.Unlock()
+
+	_server.addr, _server.r, _server.m, _server.h, _server.paused = "", nil, nil, nil, false
+	_handler.Store((*handler)(nil))
+	_running.Store(false)
+	return dns.ReCreateServer("", nil, nil)
+}
+
+// Pause closes the listener of DNS server and stops answering
+// hijacked queries, so that no query is forwarded to nameservers
+// until Resume.
+func Pause() error {
+	_server.Lock()
+	defer _server.Unlock()
+
+	if _server.addr == "" || _server.paused {
+		return nil
+	}
+	_server.paused = true
+	_handler.Store((*handler)(nil))
+	return dns.ReCreateServer("", nil, nil)
+}
+
+// Resume restarts the DNS server paused by Pause.
+func Resume() error {
+	_server.Lock()
+	defer _server.Unlock()
+
+	if !_server.paused {
+		return nil
+	}
+	if err := dns.ReCreateServer(_server.addr, _server.r, _server.m); err != nil {
+		return err
+	}
+	_server.paused = false
+	_handler.Store(_server.h)
+	return nil
 }
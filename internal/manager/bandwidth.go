@@ -0,0 +1,337 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+// minBurst is the minimum burst of token bucket, it allows
+// a whole relay buffer to pass when rate is low.
+const minBurst = 16 << 10
+
+// Bandwidth is the rate limit in bytes per second, zero means
+// unlimited.
+type Bandwidth struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+func (bw Bandwidth) String() string {
+	return formatRate(bw.Upload) + "/" + formatRate(bw.Download)
+}
+
+// ParseBandwidth parses bandwidth in the form of "UP/DOWN" or
+// "RATE" for both directions, rates are bytes per second with
+// optional K, M or G suffix, e.g. "1M/10M".
+func ParseBandwidth(s string) (Bandwidth, error) {
+	parts := strings.SplitN(s, "/", 2)
+	up, err := parseRate(parts[0])
+	if err != nil {
+		return Bandwidth{}, err
+	}
+
+	down := up
+	if len(parts) == 2 {
+		if down, err = parseRate(parts[1]); err != nil {
+			return Bandwidth{}, err
+		}
+	}
+	return Bandwidth{Upload: up, Download: down}, nil
+}
+
+func parseRate(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate: %s", s)
+	}
+	return n * unit, nil
+}
+
+func formatRate(n int64) string {
+	switch {
+	case n == 0:
+		return "0"
+	case n%(1<<30) == 0:
+		return strconv.FormatInt(n>>30, 10) + "G"
+	case n%(1<<20) == 0:
+		return strconv.FormatInt(n>>20, 10) + "M"
+	case n%(1<<10) == 0:
+		return strconv.FormatInt(n>>10, 10) + "K"
+	default:
+		return strconv.FormatInt(n, 10)
+	}
+}
+
+// bucket holds token buckets of both directions.
+type bucket struct {
+	up   *rate.Limiter
+	down *rate.Limiter
+}
+
+func newBucket(bw Bandwidth) *bucket {
+	b := &bucket{
+		up:   rate.NewLimiter(rate.Inf, minBurst),
+		down: rate.NewLimiter(rate.Inf, minBurst),
+	}
+	b.set(bw)
+	return b
+}
+
+func (b *bucket) set(bw Bandwidth) {
+	setLimit(b.up, bw.Upload)
+	setLimit(b.down, bw.Download)
+}
+
+func setLimit(l *rate.Limiter, n int64) {
+	if n == 0 {
+		l.SetLimit(rate.Inf)
+		return
+	}
+
+	burst := int(n)
+	if burst < minBurst {
+		burst = minBurst
+	}
+	l.SetBurst(burst)
+	l.SetLimit(rate.Limit(n))
+}
+
+// BandwidthStatus reports bandwidth limits.
+type BandwidthStatus struct {
+	Global    Bandwidth            `json:"global"`
+	PerSource Bandwidth            `json:"perSource"`
+	Outbounds map[string]Bandwidth `json:"outbounds"`
+}
+
+// limiter keeps buckets of global, each source IP and outbound,
+// buckets are updated in place so trackers follow new limits.
+type limiter struct {
+	mu        sync.Mutex
+	global    *bucket
+	perSource Bandwidth
+	sources   map[string]*sourceBucket
+	outbounds map[string]Bandwidth
+	buckets   map[string]*bucket
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		global:    newBucket(Bandwidth{}),
+		sources:   make(map[string]*sourceBucket),
+		outbounds: make(map[string]Bandwidth),
+		buckets:   make(map[string]*bucket),
+	}
+}
+
+// sourceBucket is the bucket of a source IP, it's removed after
+// the last connection from the source is closed.
+type sourceBucket struct {
+	*bucket
+	refs int
+}
+
+// bucketsOf returns buckets applied to connection of metadata,
+// release should be called after the connection is closed.
+func (l *limiter) bucketsOf(metadata *adapter.Metadata) []*bucket {
+	src := metadata.SrcIP.String()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sb, ok := l.sources[src]
+	if !ok {
+		sb = &sourceBucket{bucket: newBucket(l.perSource)}
+		l.sources[src] = sb
+	}
+	sb.refs++
+
+	ob, ok := l.buckets[metadata.Outbound]
+	if !ok {
+		ob = newBucket(l.outbounds[metadata.Outbound])
+		l.buckets[metadata.Outbound] = ob
+	}
+	return []*bucket{l.global, sb.bucket, ob}
+}
+
+// release drops the reference to bucket of source of metadata.
+func (l *limiter) release(metadata *adapter.Metadata) {
+	src := metadata.SrcIP.String()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if sb, ok := l.sources[src]; ok {
+		if sb.refs--; sb.refs <= 0 {
+			delete(l.sources, src)
+		}
+	}
+}
+
+// SetBandwidth sets the global bandwidth limit.
+func (m *Manager) SetBandwidth(bw Bandwidth) {
+	m.limiter.mu.Lock()
+	defer m.limiter.mu.Unlock()
+	m.limiter.global.set(bw)
+}
+
+// SetSourceBandwidth sets bandwidth limit of each source IP.
+func (m *Manager) SetSourceBandwidth(bw Bandwidth) {
+	l := m.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.perSource = bw
+	for _, b := range l.sources {
+		b.set(bw)
+	}
+}
+
+// SetOutboundBandwidth sets bandwidth limit of outbound name,
+// zero bandwidth removes the limit.
+func (m *Manager) SetOutboundBandwidth(name string, bw Bandwidth) {
+	l := m.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bw == (Bandwidth{}) {
+		delete(l.outbounds, name)
+	} else {
+		l.outbounds[name] = bw
+	}
+
+	if b, ok := l.buckets[name]; ok {
+		b.set(bw)
+	}
+}
+
+// Bandwidth returns the current bandwidth limits.
+func (m *Manager) Bandwidth() BandwidthStatus {
+	l := m.limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	outbounds := make(map[string]Bandwidth, len(l.outbounds))
+	for name, bw := range l.outbounds {
+		outbounds[name] = bw
+	}
+
+	return BandwidthStatus{
+		Global: Bandwidth{
+			Upload:   limitOf(l.global.up),
+			Download: limitOf(l.global.down),
+		},
+		PerSource: l.perSource,
+		Outbounds: outbounds,
+	}
+}
+
+func limitOf(l *rate.Limiter) int64 {
+	if l.Limit() == rate.Inf {
+		return 0
+	}
+	return int64(l.Limit())
+}
+
+// waitUpload blocks until n bytes are allowed to upload or
+// ctx is done.
+func waitUpload(ctx context.Context, buckets []*bucket, n int) error {
+	for _, b := range buckets {
+		if err := wait(ctx, b.up, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitDownload blocks until n bytes are allowed to download or
+// ctx is done.
+func waitDownload(ctx context.Context, buckets []*bucket, n int) error {
+	for _, b := range buckets {
+		if err := wait(ctx, b.down, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func wait(ctx context.Context, l *rate.Limiter, n int) error {
+	for n > 0 && l.Limit() != rate.Inf {
+		k := n
+		if burst := l.Burst(); k > burst {
+			k = burst
+		}
+		if err := l.WaitN(ctx, k); err != nil {
+			return err
+		}
+		n -= k
+	}
+	return nil
+}
+
+// allowUpload reports whether n bytes are allowed to upload now,
+// tokens are taken only if all buckets allow.
+func allowUpload(buckets []*bucket, n int) bool {
+	limiters := make([]*rate.Limiter, len(buckets))
+	for i, b := range buckets {
+		limiters[i] = b.up
+	}
+	return allow(limiters, n)
+}
+
+// allowDownload reports whether n bytes are allowed to download
+// now, tokens are taken only if all buckets allow.
+func allowDownload(buckets []*bucket, n int) bool {
+	limiters := make([]*rate.Limiter, len(buckets))
+	for i, b := range buckets {
+		limiters[i] = b.down
+	}
+	return allow(limiters, n)
+}
+
+func allow(limiters []*rate.Limiter, n int) bool {
+	now := time.Now()
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	for _, l := range limiters {
+		if n == 0 || l.Limit() == rate.Inf {
+			continue
+		}
+
+		k := n
+		if burst := l.Burst(); k > burst {
+			k = burst
+		}
+		r := l.ReserveN(now, k)
+		if !r.OK() || r.DelayFrom(now) > 0 {
+			r.CancelAt(now)
+			for _, r := range reservations {
+				r.CancelAt(now)
+			}
+			return false
+		}
+		reservations = append(reservations, r)
+	}
+	return true
+}
@@ -5,6 +5,19 @@ import (
 	"time"
 
 	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/accesslog"
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+// Close reasons of connections, recorded in access log.
+const (
+	ReasonClosed      = "closed"
+	ReasonIdleTimeout = "idle-timeout"
+	ReasonAPI         = "api"
+	ReasonShutdown    = "shutdown"
+	ReasonKillSwitch  = "kill-switch"
 )
 
 var DefaultManager *Manager
@@ -17,6 +30,8 @@ func init() {
 		downloadBlip:  atomic.NewInt64(0),
 		uploadTotal:   atomic.NewInt64(0),
 		downloadTotal: atomic.NewInt64(0),
+		idleTimeout:   atomic.NewDuration(0),
+		limiter:       newLimiter(),
 	}
 
 	go DefaultManager.handle()
@@ -30,6 +45,35 @@ type Manager struct {
 	downloadBlip  *atomic.Int64
 	uploadTotal   *atomic.Int64
 	downloadTotal *atomic.Int64
+
+	// idleTimeout closes TCP connections without any
+	// activity for the duration, zero to disable.
+	idleTimeout *atomic.Duration
+
+	// limiter limits bandwidth of connections.
+	limiter *limiter
+
+	// leaveHook is called with statistics of each connection
+	// removed from manager.
+	leaveHook atomic.Value
+}
+
+// Stats is the statistics of a closed connection.
+type Stats struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Upload   int64     `json:"upload"`
+	Download int64     `json:"download"`
+	Reason   string    `json:"reason"`
+}
+
+// LeaveHookFunc is called when a connection is removed.
+type LeaveHookFunc func(*adapter.Metadata, Stats)
+
+// SetLeaveHook sets fn to be called synchronously when a
+// connection is removed, nil to remove the hook.
+func (m *Manager) SetLeaveHook(fn LeaveHookFunc) {
+	m.leaveHook.Store(fn)
 }
 
 func (m *Manager) Join(c tracker) {
@@ -37,7 +81,39 @@ func (m *Manager) Join(c tracker) {
 }
 
 func (m *Manager) Leave(c tracker) {
-	m.connections.Delete(c.ID())
+	m.leave(c, ReasonClosed)
+}
+
+// leave removes c and writes its access log entry with reason,
+// only the first leave of c takes effect.
+func (m *Manager) leave(c tracker, reason string) {
+	if _, loaded := m.connections.LoadAndDelete(c.ID()); !loaded {
+		return
+	}
+
+	t := c.info()
+	m.limiter.release(t.Metadata)
+	stats := Stats{
+		Start:    t.Start,
+		End:      time.Now(),
+		Upload:   t.UploadTotal.Load(),
+		Download: t.DownloadTotal.Load(),
+		Reason:   reason,
+	}
+
+	if fn, _ := m.leaveHook.Load().(LeaveHookFunc); fn != nil {
+		fn(t.Metadata, stats)
+	}
+
+	if accesslog.Enabled() {
+		e := accesslog.NewEntry(t.Metadata)
+		e.Start = stats.Start
+		e.End = stats.End
+		e.Upload = stats.Upload
+		e.Download = stats.Download
+		e.Reason = stats.Reason
+		accesslog.Write(e)
+	}
 }
 
 func (m *Manager) PushUploaded(size int64) {
@@ -68,6 +144,59 @@ func (m *Manager) Snapshot() *Snapshot {
 	}
 }
 
+// CloseIf closes connections whose metadata satisfies fn with
+// reason, and returns the number of closed connections.
+func (m *Manager) CloseIf(reason string, fn func(*adapter.Metadata) bool) int {
+	var n int
+	m.connections.Range(func(key, value interface{}) bool {
+		if c := value.(tracker); fn(c.info().Metadata) {
+			_ = c.CloseWithReason(reason)
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// SetIdleTimeout sets the idle timeout of TCP connections.
+func (m *Manager) SetIdleTimeout(t time.Duration) {
+	m.idleTimeout.Store(t)
+}
+
+// closeIdle closes TCP connections idle longer than timeout,
+// closing the upstream also stops the relay of both sides.
+func (m *Manager) closeIdle(timeout time.Duration) {
+	m.connections.Range(func(key, value interface{}) bool {
+		tt, ok := value.(*tcpTracker)
+		if !ok || time.Since(tt.LastActivity.Time()) < timeout {
+			return true
+		}
+
+		metadata := tt.Metadata
+		log.Infof("[TCP] close %s <--> %s: %s", metadata.SourceAddress(), metadata.DestinationAddress(), ReasonIdleTimeout)
+		_ = tt.CloseWithReason(ReasonIdleTimeout)
+		return true
+	})
+}
+
+// Count returns the number of connections of network.
+func (m *Manager) Count(network adapter.Network) int {
+	var n int
+	m.connections.Range(func(key, value interface{}) bool {
+		if value.(tracker).info().Metadata.Net == network {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// CloseAll closes all connections with reason and returns
+// the number of closed connections.
+func (m *Manager) CloseAll(reason string) int {
+	return m.CloseIf(reason, func(*adapter.Metadata) bool { return true })
+}
+
 func (m *Manager) ResetStatistic() {
 	m.uploadTemp.Store(0)
 	m.uploadBlip.Store(0)
@@ -85,6 +214,10 @@ func (m *Manager) handle() {
 		m.uploadTemp.Store(0)
 		m.downloadBlip.Store(m.downloadTemp.Load())
 		m.downloadTemp.Store(0)
+
+		if timeout := m.idleTimeout.Load(); timeout > 0 {
+			m.closeIdle(timeout)
+		}
 	}
 }
 
@@ -1,6 +1,8 @@
 package manager
 
 import (
+	"context"
+	"encoding/json"
 	"net"
 	"time"
 
@@ -13,6 +15,9 @@ import (
 type tracker interface {
 	ID() string
 	Close() error
+	CloseWithReason(reason string) error
+
+	info() *trackerInfo
 }
 
 type trackerInfo struct {
@@ -21,6 +26,52 @@ type trackerInfo struct {
 	Metadata      *adapter.Metadata `json:"metadata"`
 	UploadTotal   *atomic.Int64     `json:"upload"`
 	DownloadTotal *atomic.Int64     `json:"download"`
+	LastActivity  *activity         `json:"lastActivity"`
+
+	// ctx is done when the tracker is closed, it cancels
+	// waiting for bandwidth.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newTrackerInfo(metadata *adapter.Metadata) *trackerInfo {
+	id, _ := uuid.NewV4()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &trackerInfo{
+		UUID:          id,
+		Start:         time.Now(),
+		Metadata:      metadata,
+		UploadTotal:   atomic.NewInt64(0),
+		DownloadTotal: atomic.NewInt64(0),
+		LastActivity:  newActivity(),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+func (t *trackerInfo) info() *trackerInfo {
+	return t
+}
+
+// activity records the last time of reading or writing.
+type activity struct {
+	nano *atomic.Int64
+}
+
+func newActivity() *activity {
+	return &activity{nano: atomic.NewInt64(time.Now().UnixNano())}
+}
+
+func (a *activity) touch() {
+	a.nano.Store(time.Now().UnixNano())
+}
+
+func (a *activity) Time() time.Time {
+	return time.Unix(0, a.nano.Load())
+}
+
+func (a *activity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.Time())
 }
 
 type tcpTracker struct {
@@ -28,21 +79,15 @@ type tcpTracker struct {
 
 	*trackerInfo
 	manager *Manager
+	buckets []*bucket
 }
 
 func NewTCPTracker(conn net.Conn, metadata *adapter.Metadata) *tcpTracker {
-	id, _ := uuid.NewV4()
-
 	t := &tcpTracker{
-		Conn:    conn,
-		manager: DefaultManager,
-		trackerInfo: &trackerInfo{
-			UUID:          id,
-			Start:         time.Now(),
-			Metadata:      metadata,
-			UploadTotal:   atomic.NewInt64(0),
-			DownloadTotal: atomic.NewInt64(0),
-		},
+		Conn:        conn,
+		manager:     DefaultManager,
+		buckets:     DefaultManager.limiter.bucketsOf(metadata),
+		trackerInfo: newTrackerInfo(metadata),
 	}
 
 	DefaultManager.Join(t)
@@ -55,45 +100,73 @@ func (tt *tcpTracker) ID() string {
 
 func (tt *tcpTracker) Read(b []byte) (int, error) {
 	n, err := tt.Conn.Read(b)
+	if werr := waitDownload(tt.ctx, tt.buckets, n); werr != nil {
+		return 0, werr
+	}
 	download := int64(n)
 	tt.manager.PushDownloaded(download)
 	tt.DownloadTotal.Add(download)
+	if n > 0 {
+		tt.LastActivity.touch()
+	}
 	return n, err
 }
 
 func (tt *tcpTracker) Write(b []byte) (int, error) {
+	if err := waitUpload(tt.ctx, tt.buckets, len(b)); err != nil {
+		return 0, err
+	}
 	n, err := tt.Conn.Write(b)
 	upload := int64(n)
 	tt.manager.PushUploaded(upload)
 	tt.UploadTotal.Add(upload)
+	if n > 0 {
+		tt.LastActivity.touch()
+	}
 	return n, err
 }
 
 func (tt *tcpTracker) Close() error {
-	tt.manager.Leave(tt)
+	return tt.CloseWithReason(ReasonClosed)
+}
+
+// CloseWithReason closes the connection, reason is recorded
+// in access log if it's the first close.
+func (tt *tcpTracker) CloseWithReason(reason string) error {
+	tt.cancel()
+	tt.manager.leave(tt, reason)
 	return tt.Conn.Close()
 }
 
+// PushUploaded counts n bytes sent without the tracker, e.g.
+// early data sent by dialer.
+func (tt *tcpTracker) PushUploaded(n int64) {
+	tt.manager.PushUploaded(n)
+	tt.UploadTotal.Add(n)
+}
+
+// CloseWrite half-closes the connection if it's supported.
+func (tt *tcpTracker) CloseWrite() error {
+	if cw, ok := tt.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return adapter.ErrCloseWriteUnsupported
+}
+
 type udpTracker struct {
 	net.PacketConn `json:"-"`
 
 	*trackerInfo
 	manager *Manager
+	buckets []*bucket
 }
 
 func NewUDPTracker(conn net.PacketConn, metadata *adapter.Metadata) *udpTracker {
-	id, _ := uuid.NewV4()
-
 	ut := &udpTracker{
-		PacketConn: conn,
-		manager:    DefaultManager,
-		trackerInfo: &trackerInfo{
-			UUID:          id,
-			Start:         time.Now(),
-			Metadata:      metadata,
-			UploadTotal:   atomic.NewInt64(0),
-			DownloadTotal: atomic.NewInt64(0),
-		},
+		PacketConn:  conn,
+		manager:     DefaultManager,
+		buckets:     DefaultManager.limiter.bucketsOf(metadata),
+		trackerInfo: newTrackerInfo(metadata),
 	}
 
 	DefaultManager.Join(ut)
@@ -104,23 +177,47 @@ func (ut *udpTracker) ID() string {
 	return ut.UUID.String()
 }
 
+// ReadFrom reads the next packet allowed by bandwidth limits,
+// packets exceeding limits are dropped.
 func (ut *udpTracker) ReadFrom(b []byte) (int, net.Addr, error) {
 	n, addr, err := ut.PacketConn.ReadFrom(b)
+	for err == nil && !allowDownload(ut.buckets, n) {
+		n, addr, err = ut.PacketConn.ReadFrom(b)
+	}
 	download := int64(n)
 	ut.manager.PushDownloaded(download)
 	ut.DownloadTotal.Add(download)
+	if n > 0 {
+		ut.LastActivity.touch()
+	}
 	return n, addr, err
 }
 
+// WriteTo writes the packet if it's allowed by bandwidth limits,
+// otherwise the packet is dropped as by a congested link, so it
+// never blocks the UDP queue.
 func (ut *udpTracker) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if !allowUpload(ut.buckets, len(b)) {
+		return len(b), nil
+	}
 	n, err := ut.PacketConn.WriteTo(b, addr)
 	upload := int64(n)
 	ut.manager.PushUploaded(upload)
 	ut.UploadTotal.Add(upload)
+	if n > 0 {
+		ut.LastActivity.touch()
+	}
 	return n, err
 }
 
 func (ut *udpTracker) Close() error {
-	ut.manager.Leave(ut)
+	return ut.CloseWithReason(ReasonClosed)
+}
+
+// CloseWithReason closes the connection, reason is recorded
+// in access log if it's the first close.
+func (ut *udpTracker) CloseWithReason(reason string) error {
+	ut.cancel()
+	ut.manager.leave(ut, reason)
 	return ut.PacketConn.Close()
 }
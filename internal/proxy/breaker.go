@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+const (
+	// defaultBreakerBackoff is the initial duration a breaker
+	// stays open before the next trial dial.
+	defaultBreakerBackoff = 5 * time.Second
+
+	// maxBreakerBackoff is the upper bound of exponential backoff.
+	maxBreakerBackoff = 5 * time.Minute
+)
+
+var (
+	errBreakerOpen = errors.New("circuit breaker is open")
+
+	// _breakerThreshold is the number of consecutive dial
+	// failures to open the breaker, zero disables breakers.
+	_breakerThreshold = atomic.NewInt64(0)
+	_breakerBackoff   = atomic.NewDuration(defaultBreakerBackoff)
+)
+
+// SetBreaker sets the consecutive failures threshold and the
+// initial backoff of circuit breakers, zero threshold disables
+// circuit breakers.
+func SetBreaker(threshold int, backoff time.Duration) {
+	if backoff <= 0 {
+		backoff = defaultBreakerBackoff
+	}
+	_breakerThreshold.Store(int64(threshold))
+	_breakerBackoff.Store(backoff)
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker is a circuit breaker of outbound. It opens after
+// consecutive dial failures, and rejects dials until backoff
+// expires, then it half-opens to let one trial dial through,
+// which either closes it or opens it again with doubled
+// backoff.
+type breaker struct {
+	mu sync.Mutex
+
+	name     string
+	state    breakerState
+	failures int64
+	backoff  time.Duration
+	retryAt  time.Time
+}
+
+func newBreaker(name string) *breaker {
+	return &breaker{name: name}
+}
+
+// allow reports whether a dial could be made now.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.retryAt) {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		return true
+	case breakerHalfOpen:
+		// only one trial dial at a time.
+		return false
+	default:
+		return true
+	}
+}
+
+// blocking reports whether the breaker is rejecting dials,
+// unlike allow, it never moves the breaker to half-open.
+func (b *breaker) blocking() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return time.Now().Before(b.retryAt)
+	case breakerHalfOpen:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.backoff = 0
+	if b.state != breakerClosed {
+		b.setState(breakerClosed)
+	}
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerHalfOpen:
+		b.backoff *= 2
+		if b.backoff > maxBreakerBackoff {
+			b.backoff = maxBreakerBackoff
+		}
+		b.open()
+	case breakerClosed:
+		b.failures++
+		if threshold := _breakerThreshold.Load(); threshold > 0 && b.failures >= threshold {
+			b.backoff = _breakerBackoff.Load()
+			b.open()
+		}
+	}
+}
+
+// abort ends a dial without result, so a half-open breaker
+// lets the next dial try.
+func (b *breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.state, b.retryAt = breakerOpen, time.Now()
+	}
+}
+
+func (b *breaker) open() {
+	b.retryAt = time.Now().Add(b.backoff)
+	b.setState(breakerOpen)
+}
+
+func (b *breaker) setState(s breakerState) {
+	b.state = s
+	switch s {
+	case breakerOpen:
+		log.Warnf("[Breaker] %s open after %d failures, retry in %s", b.name, b.failures, b.backoff)
+	case breakerHalfOpen:
+		log.Infof("[Breaker] %s half-open, trying", b.name)
+	case breakerClosed:
+		log.Infof("[Breaker] %s closed", b.name)
+	}
+}
+
+// status returns current state and the time to retry.
+func (b *breaker) status() (string, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerOpen {
+		return b.state.String(), time.Time{}
+	}
+	return b.state.String(), b.retryAt
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+// _fallback is the name of outbound to dial if the
+// breaker of chosen outbound is open.
+var _fallback = atomic.NewString("")
+
+// SetFallback sets the outbound to dial when the circuit
+// breaker of an outbound is open, empty to disable.
+func SetFallback(name string) error {
+	if _, ok := lookup(name); name != "" && !ok {
+		return fmt.Errorf("outbound %s not found", name)
+	}
+	_fallback.Store(name)
+	return nil
+}
+
+// outbound wraps Dialer with its name and health statistics,
+// the health is updated passively by the result of each dial.
+type outbound struct {
+	Dialer
+
+	name    string
+	breaker *breaker
+
+	alive     *atomic.Bool
+	lastDial  *atomic.Int64
+	lastError *atomic.String
+	failures  *atomic.Int64
+}
+
+func newOutbound(name string, dialer Dialer) *outbound {
+	o := &outbound{
+		Dialer:    dialer,
+		name:      name,
+		alive:     atomic.NewBool(true),
+		lastDial:  atomic.NewInt64(0),
+		lastError: atomic.NewString(""),
+		failures:  atomic.NewInt64(0),
+	}
+
+	// failures of direct dial are caused by destinations
+	// rather than upstream, so no breaker for them.
+	switch dialer.Type() {
+	case "", "direct", "reject":
+	default:
+		o.breaker = newBreaker(name)
+	}
+	return o
+}
+
+func (o *outbound) DialContext(ctx context.Context, metadata *adapter.Metadata) (net.Conn, error) {
+	return o.DialContextWithEarlyData(ctx, metadata, nil)
+}
+
+func (o *outbound) DialContextWithEarlyData(ctx context.Context, metadata *adapter.Metadata, data []byte) (net.Conn, error) {
+	if o.breaker != nil && !o.breaker.allow() {
+		fallback, err := o.fallback(metadata)
+		if err != nil {
+			return nil, err
+		}
+		return fallback.DialContextWithEarlyData(ctx, metadata, data)
+	}
+
+	c, err := dialEarly(ctx, o.Dialer, metadata, data)
+	o.record(err)
+	o.trip(ctx, err)
+	return c, err
+}
+
+func (o *outbound) DialUDP(metadata *adapter.Metadata) (net.PacketConn, error) {
+	if o.breaker != nil && o.breaker.blocking() {
+		fallback, err := o.fallback(metadata)
+		if err != nil {
+			return nil, err
+		}
+		return fallback.DialUDP(metadata)
+	}
+
+	pc, err := o.Dialer.DialUDP(metadata)
+	o.record(err)
+	return pc, err
+}
+
+// fallback returns the fallback outbound while breaker
+// is open, and records it in metadata.
+func (o *outbound) fallback(metadata *adapter.Metadata) (*outbound, error) {
+	name := _fallback.Load()
+	if name == "" {
+		return nil, fmt.Errorf("%s: %w", o.name, errBreakerOpen)
+	}
+
+	fallback, ok := lookup(name)
+	if !ok || fallback == o {
+		return nil, fmt.Errorf("%s: %w", o.name, errBreakerOpen)
+	}
+
+	metadata.Outbound = name
+	return fallback, nil
+}
+
+func (o *outbound) record(err error) {
+	o.lastDial.Store(time.Now().UnixNano())
+	if err != nil && err != errRejected {
+		o.alive.Store(false)
+		o.lastError.Store(err.Error())
+		o.failures.Inc()
+		return
+	}
+	o.alive.Store(true)
+	o.failures.Store(0)
+}
+
+// trip feeds breaker with the result of TCP dial, UDP dials
+// are not counted since some of them never reach upstream.
+// Only failures to reach upstream itself are counted, errors
+// of destination replied by upstream mean it's healthy, and
+// cancelled dials tell nothing.
+func (o *outbound) trip(ctx context.Context, err error) {
+	if o.breaker == nil {
+		return
+	}
+
+	switch {
+	case err == nil:
+		o.breaker.success()
+	case ctx.Err() != nil:
+		o.breaker.abort()
+	case errors.As(err, new(*upstreamError)):
+		o.breaker.failure()
+	default:
+		o.breaker.success()
+	}
+}
+
+// upstreamError is an error of connecting or handshaking to
+// upstream proxy, rather than of the destination.
+type upstreamError struct {
+	error
+}
+
+func (e *upstreamError) Unwrap() error {
+	return e.error
+}
+
+func upstream(err error) error {
+	return &upstreamError{err}
+}
+
+// Status describes an outbound and its health.
+type Status struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Addr      string    `json:"addr"`
+	Alive     bool      `json:"alive"`
+	Failures  int64     `json:"failures"`
+	LastDial  time.Time `json:"lastDial"`
+	LastError string    `json:"lastError"`
+	Breaker   string    `json:"breaker"`
+	RetryAt   time.Time `json:"retryAt"`
+}
+
+func (o *outbound) status() Status {
+	var lastDial time.Time
+	if t := o.lastDial.Load(); t != 0 {
+		lastDial = time.Unix(0, t)
+	}
+
+	var (
+		state   string
+		retryAt time.Time
+	)
+	if o.breaker != nil {
+		state, retryAt = o.breaker.status()
+	}
+
+	return Status{
+		Name:      o.name,
+		Type:      o.Type(),
+		Addr:      o.Addr(),
+		Alive:     o.alive.Load(),
+		Failures:  o.failures.Load(),
+		LastDial:  lastDial,
+		LastError: o.lastError.Load(),
+		Breaker:   state,
+		RetryAt:   retryAt,
+	}
+}
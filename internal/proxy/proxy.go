@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
+
+	"go.uber.org/atomic"
 
 	"github.com/xjasonlyu/tun2socks/internal/adapter"
 )
@@ -19,7 +23,52 @@ type Dialer interface {
 	DialUDP(*adapter.Metadata) (net.PacketConn, error)
 }
 
-var _defaultDialer Dialer = &Base{}
+// EarlyDataDialer is implemented by dialers which could send
+// the first payload of client in the same write as the proxy
+// handshake, which saves a packet and often a round trip.
+type EarlyDataDialer interface {
+	DialContextWithEarlyData(context.Context, *adapter.Metadata, []byte) (net.Conn, error)
+}
+
+const (
+	// OutboundProxy refers to the proxy registered by Register.
+	OutboundProxy = "PROXY"
+
+	// OutboundDirect refers to the builtin direct dialer.
+	OutboundDirect = "DIRECT"
+
+	// OutboundReject refers to the builtin dialer which
+	// rejects every connection.
+	OutboundReject = "REJECT"
+)
+
+var (
+	// _defaultDialer holds current *selection used by PROXY,
+	// it could be swapped at runtime, so always access it
+	// atomically.
+	_defaultDialer atomic.Value
+
+	_directDialer = newOutbound(OutboundDirect, mustNew("direct://"))
+	_rejectDialer = newOutbound(OutboundReject, mustNew("reject://"))
+
+	// _outbounds holds named outbounds registered by users.
+	_outbounds   = make(map[string]*outbound)
+	_outboundsMu sync.RWMutex
+)
+
+// selection is the outbound used by PROXY with its name, they're
+// swapped together so readers never see a mismatched pair.
+type selection struct {
+	dialer *outbound
+
+	// name is the name of outbound selected as PROXY, empty if
+	// it's registered by URL.
+	name string
+}
+
+func init() {
+	_defaultDialer.Store(&selection{dialer: newOutbound(OutboundProxy, &Base{})})
+}
 
 // New returns proxy dialer.
 func New(proxyURL string) (Dialer, error) {
@@ -35,6 +84,8 @@ func New(proxyURL string) (Dialer, error) {
 	switch proto {
 	case "direct":
 		return NewDirect(u)
+	case "reject":
+		return NewReject(u)
 	case "socks5":
 		return NewSocks5(u, user, pass)
 	case "ss", "shadowsocks":
@@ -45,6 +96,14 @@ func New(proxyURL string) (Dialer, error) {
 	return nil, fmt.Errorf("unsupported protocol: %s", proto)
 }
 
+func mustNew(proxyURL string) Dialer {
+	dialer, err := New(proxyURL)
+	if err != nil {
+		panic(err)
+	}
+	return dialer
+}
+
 // Register updates the _defaultDialer.
 func Register(proxyURL string) error {
 	dialer, err := New(proxyURL)
@@ -52,33 +111,191 @@ func Register(proxyURL string) error {
 		return err
 	}
 
-	_defaultDialer = dialer
+	_defaultDialer.Store(&selection{dialer: newOutbound(OutboundProxy, dialer)})
 	return nil
 }
 
-// Dial uses _defaultDialer to dial TCP.
+// Select updates the _defaultDialer to named outbound.
+func Select(name string) error {
+	if name == OutboundProxy {
+		return fmt.Errorf("outbound %s could not be selected", name)
+	}
+
+	o, ok := lookup(name)
+	if !ok {
+		return fmt.Errorf("outbound %s not found", name)
+	}
+
+	_defaultDialer.Store(&selection{dialer: o, name: name})
+	return nil
+}
+
+// Selected returns the name of outbound selected as PROXY,
+// empty if PROXY is registered by URL.
+func Selected() string {
+	return _defaultDialer.Load().(*selection).name
+}
+
+// Outbounds returns status of all outbounds.
+func Outbounds() []Status {
+	proxyStatus := defaultDialer().status()
+	proxyStatus.Name = OutboundProxy
+
+	statuses := []Status{
+		proxyStatus,
+		_directDialer.status(),
+		_rejectDialer.status(),
+	}
+
+	_outboundsMu.RLock()
+	defer _outboundsMu.RUnlock()
+	for _, o := range _outbounds {
+		statuses = append(statuses, o.status())
+	}
+	// keep PROXY and builtins first, then sort by name.
+	sort.Slice(statuses[3:], func(i, j int) bool {
+		return statuses[3+i].Name < statuses[3+j].Name
+	})
+	return statuses
+}
+
+func defaultDialer() *outbound {
+	return _defaultDialer.Load().(*selection).dialer
+}
+
+// RegisterOutbounds registers named outbounds, formats as NAME=URL.
+func RegisterOutbounds(raw []string) error {
+	for _, r := range raw {
+		v := strings.SplitN(r, "=", 2)
+		if len(v) != 2 || v[0] == "" || v[1] == "" {
+			return fmt.Errorf("invalid outbound format: %s", r)
+		}
+
+		dialer, err := New(v[1])
+		if err != nil {
+			return fmt.Errorf("outbound %s: %w", v[0], err)
+		}
+
+		if err := AddOutbound(v[0], dialer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddOutbound adds dialer as a named outbound.
+func AddOutbound(name string, dialer Dialer) error {
+	switch name {
+	case OutboundProxy, OutboundDirect, OutboundReject:
+		return fmt.Errorf("outbound %s is reserved", name)
+	}
+
+	_outboundsMu.Lock()
+	defer _outboundsMu.Unlock()
+
+	if _, ok := _outbounds[name]; ok {
+		return fmt.Errorf("duplicate outbound: %s", name)
+	}
+	_outbounds[name] = newOutbound(name, dialer)
+	return nil
+}
+
+// Lookup returns the dialer of outbound by name.
+func Lookup(name string) (Dialer, bool) {
+	return lookup(name)
+}
+
+func lookup(name string) (*outbound, bool) {
+	switch name {
+	case OutboundProxy:
+		return defaultDialer(), true
+	case OutboundDirect:
+		return _directDialer, true
+	case OutboundReject:
+		return _rejectDialer, true
+	}
+
+	_outboundsMu.RLock()
+	defer _outboundsMu.RUnlock()
+	o, ok := _outbounds[name]
+	return o, ok
+}
+
+// dialerOf returns the dialer chosen for metadata, the
+// _defaultDialer is used if no outbound was chosen.
+func dialerOf(metadata *adapter.Metadata) (Dialer, error) {
+	if metadata.Outbound == "" {
+		return defaultDialer(), nil
+	}
+
+	dialer, ok := lookup(metadata.Outbound)
+	if !ok {
+		return nil, fmt.Errorf("outbound %s not found", metadata.Outbound)
+	}
+	return dialer, nil
+}
+
+// Dial uses the outbound of metadata to dial TCP.
 func Dial(metadata *adapter.Metadata) (net.Conn, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), tcpConnectTimeout)
 	defer cancel()
-	return _defaultDialer.DialContext(ctx, metadata)
+	return DialContext(ctx, metadata)
+}
+
+// DialContext uses the outbound of metadata to dial TCP with ctx.
+func DialContext(ctx context.Context, metadata *adapter.Metadata) (net.Conn, error) {
+	dialer, err := dialerOf(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.DialContext(ctx, metadata)
 }
 
-// DialUDP uses _defaultDialer to dial UDP.
+// DialContextWithEarlyData uses the outbound of metadata to dial
+// TCP with ctx, and sends data as the first payload.
+func DialContextWithEarlyData(ctx context.Context, metadata *adapter.Metadata, data []byte) (net.Conn, error) {
+	dialer, err := dialerOf(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return dialEarly(ctx, dialer, metadata, data)
+}
+
+// SupportsEarlyData reports whether the outbound of metadata
+// sends early data with the proxy handshake.
+func SupportsEarlyData(metadata *adapter.Metadata) bool {
+	dialer, err := dialerOf(metadata)
+	if err != nil {
+		return false
+	}
+	if o, ok := dialer.(*outbound); ok {
+		_, ok = o.Dialer.(EarlyDataDialer)
+		return ok
+	}
+	_, ok := dialer.(EarlyDataDialer)
+	return ok
+}
+
+// DialUDP uses the outbound of metadata to dial UDP.
 func DialUDP(metadata *adapter.Metadata) (net.PacketConn, error) {
-	return _defaultDialer.DialUDP(metadata)
+	dialer, err := dialerOf(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.DialUDP(metadata)
 }
 
 // Addr returns _defaultDialer addr.
 func Addr() string {
-	return _defaultDialer.Addr()
+	return defaultDialer().Addr()
 }
 
 // Type returns _defaultDialer type.
 func Type() string {
-	return _defaultDialer.Type()
+	return defaultDialer().Type()
 }
 
 // String returns _defaultDialer URL.
 func String() string {
-	return _defaultDialer.String()
+	return defaultDialer().String()
 }
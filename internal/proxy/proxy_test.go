@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/go-shadowsocks2/core"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+var (
+	testMetadata = &adapter.Metadata{
+		Net:     adapter.TCP,
+		DstIP:   net.IPv4(1, 2, 3, 4),
+		DstPort: 80,
+	}
+	testEarlyData = []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+)
+
+// listenOnce accepts one connection on loopback and serves it by
+// fn, the first error of fn is sent to the returned channel.
+func listenOnce(t *testing.T, fn func(net.Conn) error) (string, <-chan error) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		errCh <- fn(c)
+	}()
+	return l.Addr().String(), errCh
+}
+
+func dialEarlyData(t *testing.T, d Dialer) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := newOutbound("test", d).DialContextWithEarlyData(ctx, testMetadata, testEarlyData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+// TestSocks5EarlyData checks the CONNECT request and early data
+// arrive in one read before the server replies, i.e. the client
+// sent them in one write without waiting for the reply.
+func TestSocks5EarlyData(t *testing.T) {
+	want := append([]byte{5, 1, 0}, testMetadata.SerializesSocksAddr()...)
+	want = append(want, testEarlyData...)
+
+	addr, errCh := listenOnce(t, func(c net.Conn) error {
+		greeting := make([]byte, 3)
+		if _, err := io.ReadFull(c, greeting); err != nil {
+			return err
+		}
+		if _, err := c.Write([]byte{5, 0}); err != nil {
+			return err
+		}
+
+		buf := make([]byte, 1024)
+		n, err := c.Read(buf)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Errorf("first read %q, want %q", buf[:n], want)
+		}
+		_, err = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		return err
+	})
+
+	d, err := NewSocks5(&url.URL{Scheme: "socks5", Host: addr}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dialEarlyData(t, d)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestShadowSocksEarlyData checks the target address and early
+// data are sealed in one AEAD chunk, i.e. sent in one write.
+func TestShadowSocksEarlyData(t *testing.T) {
+	const method, password = "AES-128-GCM", "password"
+
+	cipher, err := core.PickCipher(method, nil, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := append(testMetadata.SerializesSocksAddr(), testEarlyData...)
+	addr, errCh := listenOnce(t, func(c net.Conn) error {
+		buf := make([]byte, 1024)
+		n, err := cipher.StreamConn(c).Read(buf) /* reads one chunk */
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Errorf("first chunk %q, want %q", buf[:n], want)
+		}
+		return nil
+	})
+
+	d, err := NewShadowSocks(&url.URL{Scheme: "ss", Host: addr}, method, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dialEarlyData(t, d)
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+var errRejected = errors.New("rejected by rule")
+
+type Reject struct {
+	*Base
+}
+
+func NewReject(url *url.URL) (*Reject, error) {
+	return &Reject{
+		Base: &Base{
+			url: url,
+		},
+	}, nil
+}
+
+func (r *Reject) DialContext(context.Context, *adapter.Metadata) (net.Conn, error) {
+	return nil, errRejected
+}
+
+func (r *Reject) DialUDP(*adapter.Metadata) (net.PacketConn, error) {
+	return nil, errRejected
+}
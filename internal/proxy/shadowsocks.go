@@ -34,10 +34,15 @@ func NewShadowSocks(url *url.URL, method, password string) (*ShadowSocks, error)
 	}, nil
 }
 
-func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *adapter.Metadata) (c net.Conn, err error) {
+func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *adapter.Metadata) (net.Conn, error) {
+	return ss.DialContextWithEarlyData(ctx, metadata, nil)
+}
+
+// DialContextWithEarlyData sends data with the target address.
+func (ss *ShadowSocks) DialContextWithEarlyData(ctx context.Context, metadata *adapter.Metadata, data []byte) (c net.Conn, err error) {
 	c, err = dialer.DialContext(ctx, "tcp", ss.Addr())
 	if err != nil {
-		return nil, fmt.Errorf("connect to %s: %w", ss.Addr(), err)
+		return nil, upstream(fmt.Errorf("connect to %s: %w", ss.Addr(), err))
 	}
 	tcpKeepAlive(c)
 
@@ -47,11 +52,27 @@ func (ss *ShadowSocks) DialContext(ctx context.Context, metadata *adapter.Metada
 		}
 	}()
 
-	c = ss.cipher.StreamConn(c)
-	_, err = c.Write(metadata.SerializesSocksAddr())
+	c = &ssConn{Conn: ss.cipher.StreamConn(c), raw: c}
+	if _, err = c.Write(append(metadata.SerializesSocksAddr(), data...)); err != nil {
+		err = upstream(err)
+	}
 	return
 }
 
+// ssConn keeps the raw conn of stream conn to half-close it.
+type ssConn struct {
+	net.Conn
+
+	raw net.Conn
+}
+
+func (c *ssConn) CloseWrite() error {
+	if tc, ok := c.raw.(*net.TCPConn); ok {
+		return tc.CloseWrite()
+	}
+	return adapter.ErrCloseWriteUnsupported
+}
+
 func (ss *ShadowSocks) DialUDP(_ *adapter.Metadata) (net.PacketConn, error) {
 	pc, err := dialer.ListenPacket("udp", "")
 	if err != nil {
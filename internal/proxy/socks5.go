@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -30,10 +31,16 @@ func NewSocks5(url *url.URL, user, pass string) (*Socks5, error) {
 	}, nil
 }
 
-func (ss *Socks5) DialContext(ctx context.Context, metadata *adapter.Metadata) (c net.Conn, err error) {
+func (ss *Socks5) DialContext(ctx context.Context, metadata *adapter.Metadata) (net.Conn, error) {
+	return ss.DialContextWithEarlyData(ctx, metadata, nil)
+}
+
+// DialContextWithEarlyData sends data with the CONNECT request,
+// before the reply of server.
+func (ss *Socks5) DialContextWithEarlyData(ctx context.Context, metadata *adapter.Metadata, data []byte) (c net.Conn, err error) {
 	c, err = dialer.DialContext(ctx, "tcp", ss.Addr())
 	if err != nil {
-		return nil, fmt.Errorf("connect to %s: %w", ss.Addr(), err)
+		return nil, upstream(fmt.Errorf("connect to %s: %w", ss.Addr(), err))
 	}
 	tcpKeepAlive(c)
 
@@ -51,10 +58,35 @@ func (ss *Socks5) DialContext(ctx context.Context, metadata *adapter.Metadata) (
 		}
 	}
 
-	_, err = socks5.ClientHandshake(c, metadata.SerializesSocksAddr(), socks5.CmdConnect, user)
+	addr := metadata.SerializesSocksAddr()
+	w := &earlyDataWriter{
+		ReadWriter: c,
+		msg:        append([]byte{5, socks5.CmdConnect, 0}, addr...),
+		data:       data,
+	}
+	if _, err = socks5.ClientHandshake(w, addr, socks5.CmdConnect, user); err != nil {
+		err = handshakeError(err)
+		return
+	}
+
+	if !w.sent && len(data) > 0 {
+		if _, err = c.Write(data); err != nil {
+			err = upstream(err)
+		}
+	}
 	return
 }
 
+// handshakeError marks err of handshake as failure of upstream,
+// unless it's a reply about the destination, e.g. refused.
+func handshakeError(err error) error {
+	var rep socks5.Error
+	if errors.As(err, &rep) {
+		return err
+	}
+	return upstream(err)
+}
+
 func (ss *Socks5) DialUDP(_ *adapter.Metadata) (_ net.PacketConn, err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), tcpConnectTimeout)
 	defer cancel()
@@ -1,10 +1,14 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"net"
 	"time"
 
 	"github.com/xjasonlyu/clash/component/resolver"
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
 )
 
 const (
@@ -31,3 +35,47 @@ func resolveUDPAddr(network, address string) (*net.UDPAddr, error) {
 	}
 	return net.ResolveUDPAddr(network, net.JoinHostPort(ip.String(), port))
 }
+
+// dialEarly dials with d and sends data, together with the
+// handshake if d is EarlyDataDialer.
+func dialEarly(ctx context.Context, d Dialer, metadata *adapter.Metadata, data []byte) (net.Conn, error) {
+	if len(data) == 0 {
+		return d.DialContext(ctx, metadata)
+	}
+
+	if ed, ok := d.(EarlyDataDialer); ok {
+		return ed.DialContextWithEarlyData(ctx, metadata, data)
+	}
+
+	c, err := d.DialContext(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Write(data); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// earlyDataWriter appends data to the write of msg, so they
+// are sent in one write.
+type earlyDataWriter struct {
+	io.ReadWriter
+
+	msg  []byte
+	data []byte
+	sent bool
+}
+
+func (w *earlyDataWriter) Write(b []byte) (int, error) {
+	if w.sent || !bytes.Equal(b, w.msg) {
+		return w.ReadWriter.Write(b)
+	}
+
+	w.sent = true
+	if _, err := w.ReadWriter.Write(append(b[:len(b):len(b)], w.data...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,70 @@
+package rule
+
+import "net"
+
+// cidrSet is a set of IP prefixes backed by a binary trie,
+// IPv4 prefixes are stored as IPv4-mapped IPv6 prefixes.
+type cidrSet struct {
+	root *cidrNode
+}
+
+type cidrNode struct {
+	children [2]*cidrNode
+	leaf     bool
+}
+
+func newCIDRSet() *cidrSet {
+	return &cidrSet{root: &cidrNode{}}
+}
+
+// Insert adds ipNet to the set.
+func (s *cidrSet) Insert(ipNet *net.IPNet) {
+	ip := ipNet.IP.To16()
+	ones, bits := ipNet.Mask.Size()
+	if ip == nil || bits == 0 {
+		return
+	}
+	if bits == net.IPv4len*8 {
+		ones += (net.IPv6len - net.IPv4len) * 8
+	}
+
+	node := s.root
+	for i := 0; i < ones; i++ {
+		if node.leaf {
+			// covered by a shorter prefix already.
+			return
+		}
+
+		b := bitAt(ip, i)
+		if node.children[b] == nil {
+			node.children[b] = &cidrNode{}
+		}
+		node = node.children[b]
+	}
+
+	node.leaf = true
+	node.children = [2]*cidrNode{}
+}
+
+// Contains reports whether ip is covered by any prefix in the set.
+func (s *cidrSet) Contains(ip net.IP) bool {
+	if ip = ip.To16(); ip == nil {
+		return false
+	}
+
+	node := s.root
+	for i := 0; i < net.IPv6len*8; i++ {
+		if node.leaf {
+			return true
+		}
+
+		if node = node.children[bitAt(ip, i)]; node == nil {
+			return false
+		}
+	}
+	return node.leaf
+}
+
+func bitAt(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
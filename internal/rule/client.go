@@ -0,0 +1,190 @@
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/proxy"
+)
+
+// Client maps connections from source IP/CIDR to an outbound.
+type Client struct {
+	Source   string `json:"source"`
+	Outbound string `json:"outbound"`
+
+	ipNet *net.IPNet
+}
+
+type clientTable struct {
+	Default string   `json:"default"`
+	Clients []Client `json:"clients"`
+}
+
+var (
+	// _clients holds current *clientTable, it's replaced
+	// as a whole on change, so new connections pick up
+	// the latest mapping without locking.
+	_clients   atomic.Value
+	_clientsMu sync.Mutex
+
+	// ErrClientNotFound is returned by RemoveClient if source
+	// isn't in the mapping.
+	ErrClientNotFound = errors.New("client not found")
+)
+
+func init() {
+	_clients.Store(&clientTable{Default: proxy.OutboundProxy})
+}
+
+// RegisterClients adds client mappings, formats as SOURCE=OUTBOUND.
+func RegisterClients(raw []string) error {
+	for _, r := range raw {
+		v := strings.SplitN(r, "=", 2)
+		if len(v) != 2 || v[0] == "" || v[1] == "" {
+			return fmt.Errorf("invalid client format: %s", r)
+		}
+
+		if err := SetClient(v[0], v[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetClient adds or updates the outbound used by source.
+func SetClient(source, outbound string) error {
+	ipNet, err := parseSource(source)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := proxy.Lookup(outbound); !ok {
+		return fmt.Errorf("outbound %s not found", outbound)
+	}
+
+	_clientsMu.Lock()
+	defer _clientsMu.Unlock()
+
+	old := _clients.Load().(*clientTable)
+	table := &clientTable{
+		Default: old.Default,
+		Clients: make([]Client, 0, len(old.Clients)+1),
+	}
+
+	for _, c := range old.Clients {
+		if c.Source != ipNet.String() {
+			table.Clients = append(table.Clients, c)
+		}
+	}
+	table.Clients = append(table.Clients, Client{
+		Source:   ipNet.String(),
+		Outbound: outbound,
+		ipNet:    ipNet,
+	})
+
+	// longest prefix first.
+	sort.SliceStable(table.Clients, func(i, j int) bool {
+		a, _ := table.Clients[i].ipNet.Mask.Size()
+		b, _ := table.Clients[j].ipNet.Mask.Size()
+		return a > b
+	})
+
+	_clients.Store(table)
+	return nil
+}
+
+// RemoveClient removes the mapping of source, it returns
+// ErrClientNotFound if source isn't in the mapping.
+func RemoveClient(source string) error {
+	ipNet, err := parseSource(source)
+	if err != nil {
+		return err
+	}
+
+	_clientsMu.Lock()
+	defer _clientsMu.Unlock()
+
+	old := _clients.Load().(*clientTable)
+	table := &clientTable{
+		Default: old.Default,
+		Clients: make([]Client, 0, len(old.Clients)),
+	}
+
+	for _, c := range old.Clients {
+		if c.Source != ipNet.String() {
+			table.Clients = append(table.Clients, c)
+		}
+	}
+
+	if len(table.Clients) == len(old.Clients) {
+		return fmt.Errorf("%w: %s", ErrClientNotFound, source)
+	}
+
+	_clients.Store(table)
+	return nil
+}
+
+// SetDefaultClient sets the outbound used by sources
+// not in the mapping.
+func SetDefaultClient(outbound string) error {
+	if _, ok := proxy.Lookup(outbound); !ok {
+		return fmt.Errorf("outbound %s not found", outbound)
+	}
+
+	_clientsMu.Lock()
+	defer _clientsMu.Unlock()
+
+	old := _clients.Load().(*clientTable)
+	_clients.Store(&clientTable{
+		Default: outbound,
+		Clients: old.Clients,
+	})
+	return nil
+}
+
+// Clients returns a copy of current client mapping.
+func Clients() (string, []Client) {
+	table := _clients.Load().(*clientTable)
+	clients := make([]Client, len(table.Clients))
+	copy(clients, table.Clients)
+	return table.Default, clients
+}
+
+// ClientOutbound returns the outbound used by source IP.
+func ClientOutbound(ip net.IP) string {
+	table := _clients.Load().(*clientTable)
+	for _, c := range table.Clients {
+		if c.ipNet.Contains(ip) {
+			return c.Outbound
+		}
+	}
+	return table.Default
+}
+
+// parseSource parses either IP or CIDR.
+func parseSource(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source: %s", s)
+		}
+
+		bits := net.IPv6len * 8
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), net.IPv4len*8
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %s", s)
+	}
+	return ipNet, nil
+}
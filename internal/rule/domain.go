@@ -0,0 +1,87 @@
+package rule
+
+import (
+	"strings"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+type Base struct {
+	payload  string
+	outbound string
+}
+
+func (b *Base) Payload() string {
+	return b.payload
+}
+
+func (b *Base) Outbound() string {
+	return b.outbound
+}
+
+type DomainRule struct {
+	*Base
+}
+
+func NewDomain(domain, outbound string) *DomainRule {
+	return &DomainRule{
+		Base: &Base{
+			payload:  strings.ToLower(domain),
+			outbound: outbound,
+		},
+	}
+}
+
+func (d *DomainRule) Type() string {
+	return Domain
+}
+
+func (d *DomainRule) Match(metadata *adapter.Metadata) bool {
+	return metadata.Host != "" && strings.ToLower(metadata.Host) == d.payload
+}
+
+type DomainSuffixRule struct {
+	*Base
+}
+
+func NewDomainSuffix(suffix, outbound string) *DomainSuffixRule {
+	return &DomainSuffixRule{
+		Base: &Base{
+			payload:  strings.ToLower(suffix),
+			outbound: outbound,
+		},
+	}
+}
+
+func (d *DomainSuffixRule) Type() string {
+	return DomainSuffix
+}
+
+func (d *DomainSuffixRule) Match(metadata *adapter.Metadata) bool {
+	if metadata.Host == "" {
+		return false
+	}
+	host := strings.ToLower(metadata.Host)
+	return host == d.payload || strings.HasSuffix(host, "."+d.payload)
+}
+
+type DomainKeywordRule struct {
+	*Base
+}
+
+func NewDomainKeyword(keyword, outbound string) *DomainKeywordRule {
+	return &DomainKeywordRule{
+		Base: &Base{
+			payload:  strings.ToLower(keyword),
+			outbound: outbound,
+		},
+	}
+}
+
+func (d *DomainKeywordRule) Type() string {
+	return DomainKeyword
+}
+
+func (d *DomainKeywordRule) Match(metadata *adapter.Metadata) bool {
+	return metadata.Host != "" && strings.Contains(strings.ToLower(metadata.Host), d.payload)
+}
@@ -0,0 +1,49 @@
+package rule
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xjasonlyu/clash/component/trie"
+)
+
+// DomainSet is a set of domains matching their subdomains too,
+// backed by the domain trie of rule-sets.
+type DomainSet struct {
+	trie *trie.DomainTrie
+	size int
+}
+
+// ParseDomainSet parses comma separated domains, e.g.
+// "example.com,example.org", empty entries are ignored.
+func ParseDomainSet(s string) (*DomainSet, error) {
+	ds := &DomainSet{trie: trie.New()}
+	for _, d := range strings.Split(s, ",") {
+		d = strings.ToLower(strings.Trim(strings.TrimSpace(d), "."))
+		if d == "" {
+			continue
+		}
+		if err := ds.trie.Insert("+."+d, true); err != nil {
+			return nil, fmt.Errorf("%s: %w", d, err)
+		}
+		ds.size++
+	}
+	return ds, nil
+}
+
+// Len returns the number of domains, zero for nil set.
+func (ds *DomainSet) Len() int {
+	if ds == nil {
+		return 0
+	}
+	return ds.size
+}
+
+// Match reports whether host is one of the domains or their
+// subdomains, nil set matches nothing.
+func (ds *DomainSet) Match(host string) bool {
+	if ds.Len() == 0 || host == "" {
+		return false
+	}
+	return ds.trie.Search(strings.ToLower(strings.TrimSuffix(host, "."))) != nil
+}
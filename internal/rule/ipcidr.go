@@ -0,0 +1,36 @@
+package rule
+
+import (
+	"net"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+type IPCIDRRule struct {
+	*Base
+
+	ipNet *net.IPNet
+}
+
+func NewIPCIDR(cidr, outbound string) (*IPCIDRRule, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IPCIDRRule{
+		Base: &Base{
+			payload:  cidr,
+			outbound: outbound,
+		},
+		ipNet: ipNet,
+	}, nil
+}
+
+func (i *IPCIDRRule) Type() string {
+	return IPCIDR
+}
+
+func (i *IPCIDRRule) Match(metadata *adapter.Metadata) bool {
+	return metadata.DstIP != nil && i.ipNet.Contains(metadata.DstIP)
+}
@@ -0,0 +1,23 @@
+package rule
+
+import "github.com/xjasonlyu/tun2socks/internal/adapter"
+
+type MatchRule struct {
+	*Base
+}
+
+func NewMatch(outbound string) *MatchRule {
+	return &MatchRule{
+		Base: &Base{
+			outbound: outbound,
+		},
+	}
+}
+
+func (m *MatchRule) Type() string {
+	return MatchAll
+}
+
+func (m *MatchRule) Match(*adapter.Metadata) bool {
+	return true
+}
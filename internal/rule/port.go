@@ -0,0 +1,60 @@
+package rule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+type PortRule struct {
+	*Base
+
+	from uint16
+	to   uint16
+}
+
+// NewPort returns a DST-PORT rule, payload could be
+// either a single port (443) or a range (8000-8080).
+func NewPort(port, outbound string) (*PortRule, error) {
+	from, to, err := parsePortRange(port)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PortRule{
+		Base: &Base{
+			payload:  port,
+			outbound: outbound,
+		},
+		from: from,
+		to:   to,
+	}, nil
+}
+
+func (p *PortRule) Type() string {
+	return DstPort
+}
+
+func (p *PortRule) Match(metadata *adapter.Metadata) bool {
+	return metadata.DstPort >= p.from && metadata.DstPort <= p.to
+}
+
+func parsePortRange(s string) (uint16, uint16, error) {
+	parts := strings.SplitN(s, "-", 2)
+
+	from, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port: %s", s)
+	}
+
+	to := from
+	if len(parts) == 2 {
+		if to, err = strconv.ParseUint(parts[1], 10, 16); err != nil || to < from {
+			return 0, 0, fmt.Errorf("invalid port range: %s", s)
+		}
+	}
+
+	return uint16(from), uint16(to), nil
+}
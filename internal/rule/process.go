@@ -0,0 +1,72 @@
+package rule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+type ProcessRule struct {
+	*Base
+}
+
+func NewProcess(name, outbound string) *ProcessRule {
+	return &ProcessRule{
+		Base: &Base{
+			payload:  name,
+			outbound: outbound,
+		},
+	}
+}
+
+func (p *ProcessRule) Type() string {
+	return ProcessName
+}
+
+func (p *ProcessRule) Match(metadata *adapter.Metadata) bool {
+	return metadata.PID != 0 && metadata.Process == p.payload
+}
+
+type UIDRule struct {
+	*Base
+
+	from uint32
+	to   uint32
+}
+
+// NewUID returns a UID rule, payload could be either
+// a single uid (1000) or a range (1000-1999).
+func NewUID(uid, outbound string) (*UIDRule, error) {
+	parts := strings.SplitN(uid, "-", 2)
+
+	from, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid: %s", uid)
+	}
+
+	to := from
+	if len(parts) == 2 {
+		if to, err = strconv.ParseUint(parts[1], 10, 32); err != nil || to < from {
+			return nil, fmt.Errorf("invalid uid range: %s", uid)
+		}
+	}
+
+	return &UIDRule{
+		Base: &Base{
+			payload:  uid,
+			outbound: outbound,
+		},
+		from: uint32(from),
+		to:   uint32(to),
+	}, nil
+}
+
+func (u *UIDRule) Type() string {
+	return UID
+}
+
+func (u *UIDRule) Match(metadata *adapter.Metadata) bool {
+	return metadata.PID != 0 && metadata.UID >= u.from && metadata.UID <= u.to
+}
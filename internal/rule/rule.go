@@ -0,0 +1,150 @@
+package rule
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/proxy"
+)
+
+const (
+	Domain        = "DOMAIN"
+	DomainSuffix  = "DOMAIN-SUFFIX"
+	DomainKeyword = "DOMAIN-KEYWORD"
+	IPCIDR        = "IP-CIDR"
+	IPCIDR6       = "IP-CIDR6"
+	DstPort       = "DST-PORT"
+	ProcessName   = "PROCESS-NAME"
+	UID           = "UID"
+	RuleSet       = "RULE-SET"
+	MatchAll      = "MATCH"
+)
+
+// Rule matches connections by metadata and tells
+// which outbound the connection should go through.
+type Rule interface {
+	// Type returns the rule type, e.g. DOMAIN-SUFFIX.
+	Type() string
+
+	// Payload returns the rule payload, e.g. google.com.
+	Payload() string
+
+	// Outbound returns the name of outbound to dial.
+	Outbound() string
+
+	// Match reports whether metadata matches this rule.
+	Match(*adapter.Metadata) bool
+}
+
+var (
+	// _rules holds the registered rules as []Rule.
+	_rules atomic.Value
+
+	// _needProcess reports whether any of the registered
+	// rules matches by process information.
+	_needProcess = atomic.NewBool(false)
+)
+
+// Parse parses rule from string, formats as below:
+//
+//	TYPE,PAYLOAD,OUTBOUND
+//	MATCH,OUTBOUND
+func Parse(raw string) (Rule, error) {
+	parts := strings.Split(raw, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	var (
+		ruleType = strings.ToUpper(parts[0])
+		payload  string
+		outbound string
+	)
+
+	switch {
+	case ruleType == MatchAll && len(parts) == 2:
+		outbound = parts[1]
+	case ruleType != MatchAll && len(parts) == 3:
+		payload, outbound = parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("invalid rule format: %s", raw)
+	}
+
+	if _, ok := proxy.Lookup(outbound); !ok {
+		return nil, fmt.Errorf("outbound %s not found", outbound)
+	}
+
+	switch ruleType {
+	case Domain:
+		return NewDomain(payload, outbound), nil
+	case DomainSuffix:
+		return NewDomainSuffix(payload, outbound), nil
+	case DomainKeyword:
+		return NewDomainKeyword(payload, outbound), nil
+	case IPCIDR, IPCIDR6:
+		return NewIPCIDR(payload, outbound)
+	case DstPort:
+		return NewPort(payload, outbound)
+	case ProcessName:
+		return NewProcess(payload, outbound), nil
+	case UID:
+		return NewUID(payload, outbound)
+	case RuleSet:
+		return NewRuleSet(payload, outbound)
+	case MatchAll:
+		return NewMatch(outbound), nil
+	}
+
+	return nil, fmt.Errorf("unsupported rule type: %s", ruleType)
+}
+
+// Register parses and replaces all rules.
+func Register(raw []string) error {
+	var needProcess bool
+	rules := make([]Rule, 0, len(raw))
+	for _, r := range raw {
+		rule, err := Parse(r)
+		if err != nil {
+			return err
+		}
+
+		switch rule.Type() {
+		case ProcessName, UID:
+			needProcess = true
+		}
+		rules = append(rules, rule)
+	}
+
+	_rules.Store(rules)
+	_needProcess.Store(needProcess)
+	return nil
+}
+
+// NeedProcess reports whether process information
+// of connections is required by rules.
+func NeedProcess() bool {
+	return _needProcess.Load()
+}
+
+// Match returns the first rule matching metadata,
+// or nil if none of the rules matches.
+func Match(metadata *adapter.Metadata) Rule {
+	rules, _ := _rules.Load().([]Rule)
+	for _, rule := range rules {
+		if rule.Match(metadata) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// String returns readable representation of rule.
+func String(rule Rule) string {
+	if rule.Payload() == "" {
+		return rule.Type()
+	}
+	return fmt.Sprintf("%s(%s)", rule.Type(), rule.Payload())
+}
@@ -0,0 +1,335 @@
+package rule
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xjasonlyu/clash/component/trie"
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+// ruleSetCheckInterval is the interval to check
+// whether rule-set files have been changed.
+const ruleSetCheckInterval = 5 * time.Second
+
+var (
+	ruleSetMu sync.RWMutex
+	ruleSets  = make(map[string]*Set)
+)
+
+// Set is a set of domains and CIDRs loaded from file.
+// The file is watched and reloaded on change, the new
+// data is swapped in atomically, so lookups never see
+// a partially loaded set.
+type Set struct {
+	name string
+	path string
+
+	// data holds current *setData.
+	data atomic.Value
+
+	modTime time.Time
+	size    int64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type setData struct {
+	domain *trie.DomainTrie
+	ipcidr *cidrSet
+
+	domains int
+	cidrs   int
+}
+
+// RegisterRuleSets loads rule-set files, formats as
+// NAME=PATH, and starts watching them for changes.
+// Rule-sets registered before are closed and replaced,
+// rules referring to them should be registered again.
+func RegisterRuleSets(raw []string) error {
+	sets := make(map[string]*Set, len(raw))
+	for _, r := range raw {
+		v := strings.SplitN(r, "=", 2)
+		if len(v) != 2 || v[0] == "" || v[1] == "" {
+			return fmt.Errorf("invalid rule-set format: %s", r)
+		}
+
+		set, err := LoadSet(v[0], v[1])
+		if err != nil {
+			return err
+		}
+
+		if _, ok := sets[set.name]; ok {
+			return fmt.Errorf("duplicate rule-set: %s", set.name)
+		}
+		sets[set.name] = set
+	}
+
+	ruleSetMu.Lock()
+	old := ruleSets
+	ruleSets = sets
+	ruleSetMu.Unlock()
+
+	for _, set := range old {
+		set.Close()
+	}
+	for _, set := range sets {
+		go set.watch(ruleSetCheckInterval)
+	}
+	return nil
+}
+
+// CloseRuleSets stops watching all registered rule-sets.
+func CloseRuleSets() {
+	ruleSetMu.Lock()
+	old := ruleSets
+	ruleSets = make(map[string]*Set)
+	ruleSetMu.Unlock()
+
+	for _, set := range old {
+		set.Close()
+	}
+}
+
+// LookupSet returns the registered rule-set by name.
+func LookupSet(name string) (*Set, bool) {
+	ruleSetMu.RLock()
+	defer ruleSetMu.RUnlock()
+	set, ok := ruleSets[name]
+	return set, ok
+}
+
+// LoadSet loads rule-set from path.
+func LoadSet(name, path string) (*Set, error) {
+	s := &Set{
+		name: name,
+		path: path,
+		done: make(chan struct{}),
+	}
+
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Set) Name() string {
+	return s.name
+}
+
+// Match reports whether the host or destination IP of
+// metadata is contained in the set.
+func (s *Set) Match(metadata *adapter.Metadata) bool {
+	data := s.data.Load().(*setData)
+	if metadata.Host != "" && data.domain.Search(strings.ToLower(metadata.Host)) != nil {
+		return true
+	}
+	return metadata.DstIP != nil && data.ipcidr.Contains(metadata.DstIP)
+}
+
+// Close stops watching the rule-set file, the loaded
+// data is still served.
+func (s *Set) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+func (s *Set) reload() error {
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("rule-set %s: %w", s.name, err)
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("rule-set %s: %w", s.name, err)
+	}
+
+	data, err := parseSet(b)
+	if err != nil {
+		return fmt.Errorf("rule-set %s: %w", s.name, err)
+	}
+
+	s.data.Store(data)
+	s.modTime = fi.ModTime()
+	s.size = fi.Size()
+
+	log.Infof("[RULE] rule-set %s loaded: %d domains, %d CIDRs", s.name, data.domains, data.cidrs)
+	return nil
+}
+
+func (s *Set) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		fi, err := os.Stat(s.path)
+		if err != nil {
+			log.Warnf("[RULE] rule-set %s stat error: %v", s.name, err)
+			continue
+		}
+
+		if fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+			continue
+		}
+
+		// keep serving old data if reload failed.
+		if err := s.reload(); err != nil {
+			log.Warnf("[RULE] reload %v", err)
+		}
+	}
+}
+
+// parseSet parses rule-set file content, both plain text
+// lists (one entry per line) and clash-style YAML payloads
+// are supported, entries could be:
+//
+//	example.com, +.example.com, *.example.com, .example.com
+//	1.1.1.1, 10.0.0.0/8, 2001:db8::/32
+//	DOMAIN,example.com / DOMAIN-SUFFIX,example.com
+//	IP-CIDR,10.0.0.0/8 / IP-CIDR6,2001:db8::/32
+func parseSet(b []byte) (*setData, error) {
+	data := &setData{
+		domain: trie.New(),
+		ipcidr: newCIDRSet(),
+	}
+
+	var (
+		yaml    bool
+		lineNum int
+		invalid int
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if line == "payload:" {
+			yaml = true
+			continue
+		}
+
+		if yaml {
+			if !strings.HasPrefix(line, "-") {
+				return nil, fmt.Errorf("line %d: unexpected YAML content: %s", lineNum, line)
+			}
+			line = strings.TrimSpace(line[1:])
+			line = strings.Trim(line, `'"`)
+		}
+
+		if err := data.insert(line); err != nil {
+			log.Debugf("[RULE] line %d: %v", lineNum, err)
+			invalid++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if invalid > 0 {
+		log.Warnf("[RULE] %d invalid rule-set entries skipped", invalid)
+	}
+	return data, nil
+}
+
+func (d *setData) insert(entry string) error {
+	if strings.Contains(entry, ",") {
+		parts := strings.Split(entry, ",")
+		value := strings.TrimSpace(parts[1])
+
+		switch strings.ToUpper(strings.TrimSpace(parts[0])) {
+		case Domain:
+			return d.insertDomain(value)
+		case DomainSuffix:
+			return d.insertDomain("+." + value)
+		case IPCIDR, IPCIDR6:
+			return d.insertCIDR(value)
+		}
+		return fmt.Errorf("unsupported rule-set entry: %s", entry)
+	}
+
+	if strings.Contains(entry, "/") {
+		return d.insertCIDR(entry)
+	}
+
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := net.IPv6len * 8
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), net.IPv4len*8
+		}
+		d.ipcidr.Insert(&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		d.cidrs++
+		return nil
+	}
+
+	return d.insertDomain(entry)
+}
+
+func (d *setData) insertDomain(domain string) error {
+	if err := d.domain.Insert(strings.ToLower(domain), true); err != nil {
+		return fmt.Errorf("%s: %w", domain, err)
+	}
+	d.domains++
+	return nil
+}
+
+func (d *setData) insertCIDR(cidr string) error {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	d.ipcidr.Insert(ipNet)
+	d.cidrs++
+	return nil
+}
+
+type RuleSetRule struct {
+	*Base
+
+	set *Set
+}
+
+func NewRuleSet(name, outbound string) (*RuleSetRule, error) {
+	set, ok := LookupSet(name)
+	if !ok {
+		return nil, fmt.Errorf("rule-set %s not found", name)
+	}
+
+	return &RuleSetRule{
+		Base: &Base{
+			payload:  name,
+			outbound: outbound,
+		},
+		set: set,
+	}, nil
+}
+
+func (r *RuleSetRule) Type() string {
+	return RuleSet
+}
+
+func (r *RuleSetRule) Match(metadata *adapter.Metadata) bool {
+	return r.set.Match(metadata)
+}
@@ -0,0 +1,71 @@
+package sniffer
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+var httpMethods = []string{
+	"GET ", "POST ", "HEAD ", "PUT ", "DELETE ",
+	"OPTIONS ", "PATCH ", "TRACE ", "CONNECT ",
+}
+
+// SniffHTTP returns the Host header of HTTP/1 request.
+func SniffHTTP(b []byte) (string, error) {
+	if !hasMethod(b) {
+		return "", ErrNotMatched
+	}
+
+	// skip the request line.
+	i := bytes.Index(b, []byte("\r\n"))
+	if i < 0 {
+		return "", ErrNoClue
+	}
+	b = b[i+2:]
+
+	for {
+		i := bytes.Index(b, []byte("\r\n"))
+		if i < 0 {
+			return "", ErrNoClue
+		}
+
+		line := b[:i]
+		b = b[i+2:]
+
+		if len(line) == 0 {
+			// end of headers, no Host header.
+			return "", ErrNotMatched
+		}
+
+		kv := bytes.SplitN(line, []byte(":"), 2)
+		if len(kv) != 2 || !strings.EqualFold(string(bytes.TrimSpace(kv[0])), "host") {
+			continue
+		}
+
+		host := string(bytes.TrimSpace(kv[1]))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		if host, ok := validHost(host); ok {
+			return host, nil
+		}
+		return "", ErrNotMatched
+	}
+}
+
+func hasMethod(b []byte) bool {
+	for _, m := range httpMethods {
+		n := len(m)
+		if len(b) < n {
+			n = len(b)
+		}
+		if n > 0 && string(b[:n]) == m[:n] {
+			// partial method is treated as matched
+			// until more data arrives.
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,397 @@
+package sniffer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/xjasonlyu/clash/common/cache"
+)
+
+const (
+	quicVersion1 = 0x00000001
+	quicVersion2 = 0x6b3343cf
+
+	quicFrameTypePadding         = 0x00
+	quicFrameTypePing            = 0x01
+	quicFrameTypeAck             = 0x02
+	quicFrameTypeAckECN          = 0x03
+	quicFrameTypeCrypto          = 0x06
+	quicFrameTypeConnectionClose = 0x1c
+
+	quicSampleLen = 16
+
+	// maxQUICCryptoSize bounds CRYPTO data buffered for a QUIC
+	// connection whose ClientHello spans several datagrams.
+	maxQUICCryptoSize = 16 * 1024
+
+	quicStateAge  = 10 /* seconds */
+	maxQUICStates = 1024
+)
+
+var (
+	quicSaltV1 = []byte{
+		0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+		0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+	}
+	quicSaltV2 = []byte{
+		0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93,
+		0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9,
+	}
+
+	errInvalidQUICPacket = errors.New("invalid QUIC packet")
+)
+
+// SniffQUIC returns the server name in ClientHello carried by
+// QUIC v1/v2 Initial packets of datagram b. It decrypts them
+// with the initial secrets derived from Destination Connection
+// ID. CRYPTO data is buffered by DCID, so ClientHello spanning
+// several datagrams is reassembled, and ErrNoClue is returned
+// until it's complete.
+func SniffQUIC(b []byte) (string, error) {
+	dcid, err := initialDCID(b)
+	if err != nil {
+		return "", err
+	}
+
+	/* datagrams after the decision are not decrypted again */
+	if state, ok := _quicStates.Get(string(dcid)); ok {
+		if host, err := state.(*quicState).result(); !errors.Is(err, ErrNoClue) {
+			return host, err
+		}
+	}
+
+	var crypto cryptoStream
+	for len(b) > 0 {
+		payload, rest, err := openInitial(b)
+		if err != nil {
+			if crypto.len() > 0 {
+				break /* coalesced non-initial packets */
+			}
+			return "", err
+		}
+		b = rest
+
+		if err := crypto.readFrames(payload); err != nil {
+			return "", err
+		}
+	}
+	return loadQUICState(string(dcid)).add(&crypto)
+}
+
+// initialDCID returns Destination Connection ID of the long
+// header packet at the start of b.
+func initialDCID(b []byte) ([]byte, error) {
+	// long header with fixed bit set.
+	if len(b) < 7 || b[0]&0xc0 != 0xc0 {
+		return nil, ErrNotMatched
+	}
+
+	s := reader(b[5:])
+	dcid, ok := s.readVector(1)
+	if !ok || len(dcid) > 20 {
+		return nil, errInvalidQUICPacket
+	}
+	return dcid, nil
+}
+
+var (
+	// _quicStates holds CRYPTO data of QUIC connections by DCID
+	// until their ClientHello is complete, and the result after.
+	_quicStates = cache.NewLRUCache(cache.WithAge(quicStateAge), cache.WithSize(maxQUICStates))
+	_quicMu     sync.Mutex
+)
+
+type quicState struct {
+	mu     sync.Mutex
+	crypto cryptoStream
+
+	done bool
+	host string
+	err  error
+}
+
+func loadQUICState(dcid string) *quicState {
+	_quicMu.Lock()
+	defer _quicMu.Unlock()
+
+	if state, ok := _quicStates.Get(dcid); ok {
+		return state.(*quicState)
+	}
+	state := &quicState{}
+	_quicStates.Set(dcid, state)
+	return state
+}
+
+// result returns the sniffed result, or ErrNoClue if ClientHello
+// is not complete yet.
+func (qs *quicState) result() (string, error) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	if !qs.done {
+		return "", ErrNoClue
+	}
+	return qs.host, qs.err
+}
+
+// add merges CRYPTO frames of a datagram and sniffs ClientHello
+// from the contiguous data.
+func (qs *quicState) add(crypto *cryptoStream) (string, error) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+
+	if qs.done {
+		return qs.host, qs.err
+	}
+	for offset, data := range crypto.frames {
+		qs.crypto.add(offset, data)
+	}
+
+	host, err := "", ErrNoClue /* frame at offset 0 is missing */
+	if msg := qs.crypto.bytes(); len(msg) > 0 {
+		host, err = ServerName(msg)
+	}
+	if errors.Is(err, ErrNoClue) {
+		if qs.crypto.size <= maxQUICCryptoSize {
+			return "", ErrNoClue
+		}
+		err = ErrNotMatched
+	}
+
+	qs.done, qs.host, qs.err = true, host, err
+	qs.crypto = cryptoStream{}
+	return host, err
+}
+
+// openInitial removes header protection of Initial packet at
+// the start of b and returns its decrypted payload and the
+// remaining bytes of datagram.
+func openInitial(b []byte) ([]byte, []byte, error) {
+	// long header with fixed bit set.
+	if len(b) < 7 || b[0]&0xc0 != 0xc0 {
+		return nil, nil, ErrNotMatched
+	}
+
+	var salt []byte
+	var v2 bool
+	switch binary.BigEndian.Uint32(b[1:5]) {
+	case quicVersion1:
+		salt = quicSaltV1
+		if b[0]&0x30 != 0x00 {
+			return nil, nil, ErrNotMatched
+		}
+	case quicVersion2:
+		salt, v2 = quicSaltV2, true
+		if b[0]&0x30 != 0x10 {
+			return nil, nil, ErrNotMatched
+		}
+	default:
+		return nil, nil, ErrNotMatched
+	}
+
+	s := reader(b[5:])
+	dcid, ok := s.readVector(1)
+	if !ok || len(dcid) > 20 {
+		return nil, nil, errInvalidQUICPacket
+	}
+	if _, ok := s.readVector(1); !ok { /* scid */
+		return nil, nil, errInvalidQUICPacket
+	}
+
+	tokenLen, ok := s.readVarint()
+	if !ok || !s.skip(int(tokenLen)) {
+		return nil, nil, errInvalidQUICPacket
+	}
+
+	length, ok := s.readVarint()
+	if !ok || uint64(len(s)) < length || length < 4+quicSampleLen {
+		return nil, nil, errInvalidQUICPacket
+	}
+
+	pnOffset := len(b) - len(s)
+	packet := make([]byte, pnOffset+int(length))
+	copy(packet, b)
+	rest := b[len(packet):]
+
+	key, iv, hp := initialKeys(salt, dcid, v2)
+
+	block, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, nil, err
+	}
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, packet[pnOffset+4:pnOffset+4+quicSampleLen])
+
+	packet[0] ^= mask[0] & 0x0f
+	pnLen := int(packet[0]&0x03) + 1
+
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(packet[pnOffset+i])
+	}
+
+	block, err = aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, len(iv))
+	copy(nonce, iv)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+
+	header := packet[:pnOffset+pnLen]
+	payload, err := aead.Open(nil, nonce, packet[len(header):], header)
+	if err != nil {
+		return nil, nil, errInvalidQUICPacket
+	}
+	return payload, rest, nil
+}
+
+// initialKeys derives client Initial packet protection keys,
+// see RFC 9001 Section 5.2 and RFC 9369 Section 3.3.
+func initialKeys(salt, dcid []byte, v2 bool) (key, iv, hp []byte) {
+	prefix := "quic "
+	if v2 {
+		prefix = "quicv2 "
+	}
+
+	initialSecret := hkdfExtract(salt, dcid)
+	secret := hkdfExpandLabel(initialSecret, "client in", sha256.Size)
+	key = hkdfExpandLabel(secret, prefix+"key", 16)
+	iv = hkdfExpandLabel(secret, prefix+"iv", 12)
+	hp = hkdfExpandLabel(secret, prefix+"hp", 16)
+	return
+}
+
+func hkdfExtract(salt, secret []byte) []byte {
+	h := hmac.New(sha256.New, salt)
+	h.Write(secret)
+	return h.Sum(nil)
+}
+
+// hkdfExpandLabel implements TLS 1.3 HKDF-Expand-Label with
+// empty context, length must not exceed SHA-256 size.
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	label = "tls13 " + label
+	info := make([]byte, 0, 4+len(label)+1)
+	info = append(info, byte(length>>8), byte(length), byte(len(label)))
+	info = append(info, label...)
+	info = append(info, 0 /* context */, 1 /* counter */)
+
+	h := hmac.New(sha256.New, secret)
+	h.Write(info)
+	return h.Sum(nil)[:length]
+}
+
+// cryptoStream reassembles CRYPTO frames by offset.
+type cryptoStream struct {
+	frames map[uint64][]byte
+	size   int
+}
+
+func (cs *cryptoStream) len() int {
+	return len(cs.frames)
+}
+
+func (cs *cryptoStream) add(offset uint64, data []byte) {
+	if cs.frames == nil {
+		cs.frames = make(map[uint64][]byte)
+	}
+	cs.size += len(data) - len(cs.frames[offset])
+	cs.frames[offset] = data
+}
+
+func (cs *cryptoStream) readFrames(b []byte) error {
+	s := reader(b)
+	for len(s) > 0 {
+		frameType, ok := s.readVarint()
+		if !ok {
+			return errInvalidQUICPacket
+		}
+
+		switch frameType {
+		case quicFrameTypePadding, quicFrameTypePing:
+		case quicFrameTypeCrypto:
+			offset, ok1 := s.readVarint()
+			length, ok2 := s.readVarint()
+			if !ok1 || !ok2 || uint64(len(s)) < length {
+				return errInvalidQUICPacket
+			}
+			cs.add(offset, s[:length])
+			s = s[length:]
+		case quicFrameTypeAck, quicFrameTypeAckECN:
+			// largest, delay, range count, first range.
+			var v [4]uint64
+			for i := range v {
+				if v[i], ok = s.readVarint(); !ok {
+					return errInvalidQUICPacket
+				}
+			}
+			n := 2 * v[2]
+			if frameType == quicFrameTypeAckECN {
+				n += 3
+			}
+			for i := uint64(0); i < n; i++ {
+				if _, ok := s.readVarint(); !ok {
+					return errInvalidQUICPacket
+				}
+			}
+		case quicFrameTypeConnectionClose:
+			return ErrNotMatched
+		default:
+			// frames not allowed in Initial packets.
+			return errInvalidQUICPacket
+		}
+	}
+	return nil
+}
+
+// bytes returns the contiguous stream data from offset 0,
+// frames may arrive out of order or overlap each other.
+func (cs *cryptoStream) bytes() []byte {
+	var b []byte
+	for {
+		n := uint64(len(b))
+		for offset, data := range cs.frames {
+			if offset <= n && offset+uint64(len(data)) > n {
+				b = append(b, data[n-offset:]...)
+				break
+			}
+		}
+
+		if uint64(len(b)) == n {
+			return b
+		}
+	}
+}
+
+// readVarint reads a QUIC variable-length integer.
+func (r *reader) readVarint() (uint64, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+
+	n := 1 << ((*r)[0] >> 6)
+	if len(*r) < n {
+		return 0, false
+	}
+
+	v := uint64((*r)[0] & 0x3f)
+	for _, c := range (*r)[1:n] {
+		v = v<<8 | uint64(c)
+	}
+	*r = (*r)[n:]
+	return v, true
+}
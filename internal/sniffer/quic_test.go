@@ -0,0 +1,139 @@
+package sniffer
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Test vectors of RFC 9001 Appendix A.
+var (
+	rfcDCID = unhex("8394c8f03e515708")
+
+	// A.1, client initial keys.
+	rfcKey = unhex("1f369613dd76d5467730efcbe3b1a22d")
+	rfcIV  = unhex("fa044b2f42a3fd3b46fb255c")
+	rfcHP  = unhex("9f50449e04a0e810283a1e9933adedd2")
+
+	// A.2, CRYPTO frame of client Initial packet number 2.
+	rfcCryptoFrame = unhex(`
+		060040f1010000ed0303ebf8fa56f12939b9584a3896472ec40bb863cfd3e868
+		04fe3a47f06a2b69484c00000413011302010000c000000010000e00000b6578
+		616d706c652e636f6dff01000100000a00080006001d00170018001000070005
+		04616c706e000500050100000000003300260024001d00209370b2c9caa47fba
+		baf4559fedba753de171fa71f50f1ce15d43e994ec74d748002b000302030400
+		0d0010000e0403050306030203080408050806002d00020101001c0002400100
+		3900320408ffffffffffffffff05048000ffff07048000ffff08011001048000
+		75300901100f088394c8f03e51570806048000ffff`)
+
+	// A.2, the start of protected client Initial packet.
+	rfcProtected = unhex(`
+		c000000001088394c8f03e5157080000449e7b9aec34d1b1c98dd7689fb8ec11
+		d242b123dc9bd8bab936b47d92ec356c0bab7df5976d27cd449f63300099f399`)
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// sealInitial protects a QUIC v1 client Initial packet with 4
+// bytes packet number, payload is padded to 1162 bytes as A.2.
+func sealInitial(dcid []byte, pn uint32, payload []byte) []byte {
+	key, iv, hp := initialKeys(quicSaltV1, dcid, false)
+
+	padded := make([]byte, 1162)
+	copy(padded, payload)
+
+	header := []byte{0xc3, 0, 0, 0, 1, byte(len(dcid))}
+	header = append(header, dcid...)
+	header = append(header, 0 /* scid */, 0 /* token */)
+	header = append(header, 0x40|byte((len(padded)+4+16)>>8), byte(len(padded)+4+16))
+	pnOffset := len(header)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[pnOffset:], pn)
+
+	nonce := append([]byte(nil), iv...)
+	for i := 0; i < 4; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	sealed := aead.Seal(nil, nonce, padded, header)
+
+	mask := make([]byte, aes.BlockSize)
+	block, _ = aes.NewCipher(hp)
+	block.Encrypt(mask, sealed[:quicSampleLen])
+	header[0] ^= mask[0] & 0x0f
+	for i := 0; i < 4; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+	}
+	return append(header, sealed...)
+}
+
+func cryptoFrame(offset int, data []byte) []byte {
+	return append([]byte{
+		quicFrameTypeCrypto,
+		0x40 | byte(offset>>8), byte(offset),
+		0x40 | byte(len(data)>>8), byte(len(data)),
+	}, data...)
+}
+
+func TestInitialKeys(t *testing.T) {
+	key, iv, hp := initialKeys(quicSaltV1, rfcDCID, false)
+	if !bytes.Equal(key, rfcKey) || !bytes.Equal(iv, rfcIV) || !bytes.Equal(hp, rfcHP) {
+		t.Errorf("keys %x %x %x, want %x %x %x", key, iv, hp, rfcKey, rfcIV, rfcHP)
+	}
+}
+
+func TestSniffQUIC(t *testing.T) {
+	packet := sealInitial(rfcDCID, 2, rfcCryptoFrame)
+	if !bytes.HasPrefix(packet, rfcProtected) {
+		t.Fatalf("protected packet %x, want prefix %x", packet[:len(rfcProtected)], rfcProtected)
+	}
+
+	host, err := SniffQUIC(packet)
+	if err != nil || host != "example.com" {
+		t.Errorf("SniffQUIC() = %q, %v, want example.com", host, err)
+	}
+}
+
+func TestSniffQUICSplit(t *testing.T) {
+	hello := rfcCryptoFrame[4:]
+	first, second := cryptoFrame(0, hello[:100]), cryptoFrame(100, hello[100:])
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"in order", [][]byte{first, second}},
+		{"out of order", [][]byte{second, first}},
+		{"duplicate", [][]byte{first, first, second}},
+	}
+
+	for i, tt := range tests {
+		dcid := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, byte(i)}
+		t.Run(tt.name, func(t *testing.T) {
+			for j, frame := range tt.frames {
+				host, err := SniffQUIC(sealInitial(dcid, uint32(j), frame))
+				if j < len(tt.frames)-1 {
+					if !errors.Is(err, ErrNoClue) {
+						t.Fatalf("datagram %d: SniffQUIC() = %q, %v, want ErrNoClue", j, host, err)
+					}
+					continue
+				}
+				if err != nil || host != "example.com" {
+					t.Fatalf("datagram %d: SniffQUIC() = %q, %v, want example.com", j, host, err)
+				}
+			}
+		})
+	}
+}
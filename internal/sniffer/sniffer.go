@@ -0,0 +1,53 @@
+// Package sniffer recovers domain names from the first
+// bytes sent by clients.
+package sniffer
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	// ErrNoClue means more data is required to decide.
+	ErrNoClue = errors.New("need more data")
+
+	// ErrNotMatched means the data is not of this protocol.
+	ErrNotMatched = errors.New("protocol not matched")
+)
+
+// SniffTCP tries TLS and HTTP sniffers on b in order.
+func SniffTCP(b []byte) (string, error) {
+	var noClue bool
+	for _, sniff := range []func([]byte) (string, error){SniffTLS, SniffHTTP} {
+		host, err := sniff(b)
+		if err == nil {
+			return host, nil
+		}
+		if errors.Is(err, ErrNoClue) {
+			noClue = true
+		}
+	}
+
+	if noClue {
+		return "", ErrNoClue
+	}
+	return "", ErrNotMatched
+}
+
+// validHost normalizes host and reports whether it's a
+// domain name, IP literals are not considered valid.
+func validHost(host string) (string, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" || len(host) > 253 || net.ParseIP(host) != nil {
+		return "", false
+	}
+
+	for i := 0; i < len(host); i++ {
+		c := host[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return "", false
+		}
+	}
+	return host, true
+}
@@ -0,0 +1,173 @@
+package sniffer
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+	extensionServerName      = 0x0000
+	serverNameTypeHostName   = 0x00
+
+	recordHeaderLen    = 5
+	handshakeHeaderLen = 4
+)
+
+var errInvalidClientHello = errors.New("invalid client hello")
+
+// SniffTLS returns the server name in TLS ClientHello, the
+// handshake message may span several TLS records.
+func SniffTLS(b []byte) (string, error) {
+	var msg []byte
+	for {
+		if len(b) < recordHeaderLen {
+			return "", ErrNoClue
+		}
+
+		if b[0] != recordTypeHandshake || b[1] != 0x03 {
+			return "", ErrNotMatched
+		}
+
+		length := int(binary.BigEndian.Uint16(b[3:5]))
+		if len(b) < recordHeaderLen+length {
+			return "", ErrNoClue
+		}
+
+		msg = append(msg, b[recordHeaderLen:recordHeaderLen+length]...)
+		b = b[recordHeaderLen+length:]
+
+		if len(msg) < handshakeHeaderLen {
+			continue
+		}
+
+		if msg[0] != handshakeTypeClientHello {
+			return "", ErrNotMatched
+		}
+
+		msgLen := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+		if len(msg) >= handshakeHeaderLen+msgLen {
+			return ServerName(msg[:handshakeHeaderLen+msgLen])
+		}
+	}
+}
+
+// ServerName returns the server name extension of ClientHello
+// handshake message, starting with the handshake header.
+func ServerName(msg []byte) (string, error) {
+	if len(msg) < handshakeHeaderLen || msg[0] != handshakeTypeClientHello {
+		return "", ErrNotMatched
+	}
+
+	msgLen := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if len(msg) < handshakeHeaderLen+msgLen {
+		return "", ErrNoClue
+	}
+
+	s := reader(msg[handshakeHeaderLen : handshakeHeaderLen+msgLen])
+	// legacy_version & random
+	if !s.skip(2 + 32) {
+		return "", errInvalidClientHello
+	}
+
+	// legacy_session_id, cipher_suites, legacy_compression_methods
+	if _, ok := s.readVector(1); !ok {
+		return "", errInvalidClientHello
+	}
+	if _, ok := s.readVector(2); !ok {
+		return "", errInvalidClientHello
+	}
+	if _, ok := s.readVector(1); !ok {
+		return "", errInvalidClientHello
+	}
+
+	extensions, ok := s.readVector(2)
+	if !ok {
+		return "", ErrNotMatched /* no extensions */
+	}
+
+	for len(extensions) > 0 {
+		extType, ok1 := extensions.readUint16()
+		extData, ok2 := extensions.readVector(2)
+		if !ok1 || !ok2 {
+			return "", errInvalidClientHello
+		}
+
+		if extType != extensionServerName {
+			continue
+		}
+
+		list, ok := extData.readVector(2)
+		if !ok {
+			return "", errInvalidClientHello
+		}
+
+		for len(list) > 0 {
+			nameType, ok1 := list.readUint8()
+			name, ok2 := list.readVector(2)
+			if !ok1 || !ok2 {
+				return "", errInvalidClientHello
+			}
+
+			if nameType != serverNameTypeHostName {
+				continue
+			}
+
+			if host, ok := validHost(string(name)); ok {
+				return host, nil
+			}
+			return "", errInvalidClientHello
+		}
+	}
+
+	return "", ErrNotMatched
+}
+
+// reader is a minimal cursor over TLS encoded data.
+type reader []byte
+
+func (r *reader) skip(n int) bool {
+	if len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *reader) readUint8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v, true
+}
+
+func (r *reader) readUint16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v, true
+}
+
+// readVector reads a vector prefixed by lenBytes length.
+func (r *reader) readVector(lenBytes int) (reader, bool) {
+	if len(*r) < lenBytes {
+		return nil, false
+	}
+
+	var n int
+	for _, c := range (*r)[:lenBytes] {
+		n = n<<8 | int(c)
+	}
+
+	if len(*r) < lenBytes+n {
+		return nil, false
+	}
+	v := (*r)[lenBytes : lenBytes+n]
+	*r = (*r)[lenBytes+n:]
+	return v, true
+}
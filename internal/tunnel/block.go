@@ -0,0 +1,86 @@
+package tunnel
+
+import (
+	"fmt"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/clash/common/cache"
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/rule"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+// udpBlock rejects UDP flows to ports or domains, so clients
+// like browsers fall back to TCP without waiting for timeout.
+type udpBlock struct {
+	ports   portRanges
+	domains *rule.DomainSet
+
+	rejected *cache.LruCache /* flowID -> struct{} */
+}
+
+var (
+	_udpBlock atomic.Value
+
+	// _udpBlocked counts UDP packets rejected by udpBlock.
+	_udpBlocked = atomic.NewInt64(0)
+)
+
+func init() {
+	_udpBlock.Store(&udpBlock{})
+}
+
+// SetUDPBlock rejects UDP packets to destination ports, e.g.
+// "443,8443", or to domains and their subdomains found by fake
+// DNS or QUIC sniffing, e.g. "example.com,example.org".
+func SetUDPBlock(ports, domains string) error {
+	pr, err := parsePortRanges(ports)
+	if err != nil {
+		return err
+	}
+
+	ds, err := rule.ParseDomainSet(domains)
+	if err != nil {
+		return fmt.Errorf("invalid blocked domains: %w", err)
+	}
+
+	_udpBlock.Store(&udpBlock{ports: pr, domains: ds, rejected: newFlowVerdicts()})
+	return nil
+}
+
+// UDPBlocked returns the number of UDP packets rejected.
+func UDPBlocked() int64 {
+	return _udpBlocked.Load()
+}
+
+// shouldBlockUDP reports whether packet should be rejected, it's
+// called by stack before queueing, so only ports and flows whose
+// domains were blocked on routing are checked.
+func shouldBlockUDP(metadata *adapter.Metadata) bool {
+	b := _udpBlock.Load().(*udpBlock)
+	if b.ports.contains(metadata.DstPort) {
+		return true
+	}
+	return b.domains.Len() > 0 && b.rejected.Exist(newFlowID(metadata))
+}
+
+// blockUDPDomains reports whether UDP flows are blocked by domain.
+func blockUDPDomains() bool {
+	return _udpBlock.Load().(*udpBlock).domains.Len() > 0
+}
+
+// blockUDPHost reports whether the flow of metadata to host found
+// on routing is blocked, later packets of blocked flows are
+// rejected by shouldBlockUDP.
+func blockUDPHost(metadata *adapter.Metadata, host string) bool {
+	b := _udpBlock.Load().(*udpBlock)
+	if !b.domains.Match(host) {
+		return false
+	}
+
+	b.rejected.Set(newFlowID(metadata), struct{}{})
+	_udpBlocked.Inc()
+	log.Debugf("[UDP] %s --> %s blocked", metadata.SourceAddress(), metadata.DestinationAddress())
+	return true
+}
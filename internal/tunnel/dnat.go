@@ -0,0 +1,211 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/clash/component/resolver"
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+// anyPort matches any port of destination, or keeps the
+// original port in target.
+const anyPort = "*"
+
+// DNATRule rewrites destinations matched by Match to Target,
+// and dials through Outbound if it's not empty.
+type DNATRule struct {
+	Match    string `json:"match"`
+	Target   string `json:"target"`
+	Outbound string `json:"outbound,omitempty"`
+
+	ipNet *net.IPNet
+	host  string
+	port  uint16 /* zero for any */
+
+	toIP   net.IP
+	toHost string
+	toPort uint16 /* zero to keep */
+}
+
+// _dnatRules holds []*DNATRule in order of matching.
+var _dnatRules atomic.Value
+
+func init() {
+	_dnatRules.Store([]*DNATRule(nil))
+}
+
+// ParseDNATRule parses rule like "10.0.0.5:5432=127.0.0.1:15432"
+// with an optional outbound, e.g. "db.local:*=127.0.0.1:*,DIRECT".
+// Destination could be an IP, a CIDR or a host, and port "*"
+// matches any port or keeps the original port.
+func ParseDNATRule(s string) (*DNATRule, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("invalid DNAT rule: %s", s)
+	}
+
+	target, outbound := kv[1], ""
+	if i := strings.LastIndex(target, ","); i >= 0 {
+		target, outbound = target[:i], strings.TrimSpace(target[i+1:])
+	}
+	return NewDNATRule(strings.TrimSpace(kv[0]), strings.TrimSpace(target), outbound)
+}
+
+// NewDNATRule returns DNATRule rewriting match to target.
+func NewDNATRule(match, target, outbound string) (*DNATRule, error) {
+	r := &DNATRule{Match: match, Target: target, Outbound: outbound}
+
+	host, port, err := splitDNATAddr(match)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNAT match %s: %w", match, err)
+	}
+	r.port = port
+	if _, ipNet, err := net.ParseCIDR(host); err == nil {
+		r.ipNet = ipNet
+	} else if ip := net.ParseIP(host); ip != nil {
+		r.ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}
+	} else {
+		r.host = strings.ToLower(host)
+	}
+
+	host, port, err = splitDNATAddr(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNAT target %s: %w", target, err)
+	}
+	r.toPort = port
+	if ip := net.ParseIP(host); ip != nil {
+		r.toIP = ip
+	} else {
+		r.toHost = host
+	}
+	return r, nil
+}
+
+func splitDNATAddr(s string) (string, uint16, error) {
+	host, p, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", 0, err
+	}
+	if host == "" {
+		return "", 0, fmt.Errorf("empty host")
+	}
+	if p == anyPort {
+		return host, 0, nil
+	}
+
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil || port == 0 {
+		return "", 0, fmt.Errorf("invalid port: %s", p)
+	}
+	return host, uint16(port), nil
+}
+
+func (r *DNATRule) String() string {
+	if r.Outbound != "" {
+		return fmt.Sprintf("%s=%s,%s", r.Match, r.Target, r.Outbound)
+	}
+	return fmt.Sprintf("%s=%s", r.Match, r.Target)
+}
+
+func (r *DNATRule) match(metadata *adapter.Metadata) bool {
+	if r.port != 0 && r.port != metadata.DstPort {
+		return false
+	}
+	if r.ipNet != nil {
+		return r.ipNet.Contains(metadata.DstIP)
+	}
+	return strings.EqualFold(metadata.Host, r.host)
+}
+
+// SetDNATRules replaces DNAT rules, they are matched in order.
+func SetDNATRules(rules []*DNATRule) {
+	_dnatRules.Store(rules)
+}
+
+// GetDNATRules returns current DNAT rules.
+func GetDNATRules() []*DNATRule {
+	return _dnatRules.Load().([]*DNATRule)
+}
+
+// rewriteDestination rewrites destination of metadata by the
+// first matched DNAT rule, and returns the rule, nil if none.
+func rewriteDestination(metadata *adapter.Metadata) *DNATRule {
+	for _, r := range GetDNATRules() {
+		if !r.match(metadata) {
+			continue
+		}
+
+		metadata.OrigDst = metadata.DestinationAddress()
+		if r.toIP != nil {
+			metadata.DstIP = r.toIP
+			metadata.Host = ""
+		} else {
+			metadata.Host = r.toHost
+		}
+		if r.toPort != 0 {
+			metadata.DstPort = r.toPort
+		}
+		return r
+	}
+	return nil
+}
+
+// routeDNAT overrides the routed outbound of metadata by the
+// outbound of matched DNAT rule.
+func routeDNAT(metadata *adapter.Metadata, r *DNATRule) {
+	if r != nil && r.Outbound != "" {
+		metadata.Outbound = r.Outbound
+		metadata.Rule = "DNAT," + r.String()
+	}
+}
+
+// dnatOrigins maps rewritten destinations of a NAT entry back
+// to the original ones, so replies come from where the client
+// sent to.
+type dnatOrigins struct {
+	m     sync.Map /* rewritten address -> net.Addr */
+	hosts sync.Map /* host:port -> resolved ip:port */
+}
+
+// add records that packets to the destination of metadata were
+// originally sent to addr. Host destinations are resolved once,
+// as replies to them come from the resolved address.
+func (o *dnatOrigins) add(metadata *adapter.Metadata, addr net.Addr) {
+	key := metadata.DestinationAddress()
+	o.m.Store(key, addr)
+	if metadata.Host == "" {
+		return
+	}
+
+	var resolved string
+	if v, ok := o.hosts.Load(key); ok {
+		resolved = v.(string)
+	} else {
+		if ip, err := resolver.ResolveIP(metadata.Host); err == nil {
+			resolved = (&net.UDPAddr{IP: ip, Port: int(metadata.DstPort)}).String()
+		}
+		o.hosts.Store(key, resolved)
+	}
+	if resolved != "" {
+		o.m.Store(resolved, addr)
+	}
+}
+
+// lookup returns the original address of the reply from addr.
+func (o *dnatOrigins) lookup(addr net.Addr) (net.Addr, bool) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil, false
+	}
+
+	if v, ok := o.m.Load(udpAddr.String()); ok {
+		return v.(net.Addr), true
+	}
+	return nil, false
+}
@@ -0,0 +1,194 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/dns"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+const (
+	dnsDefaultPort = 53
+	dnsTCPTimeout  = 10 * time.Second
+
+	// maxDNSHijacks is the maximum number of hijacked UDP queries
+	// answered concurrently, more queries are dropped.
+	maxDNSHijacks = 256
+)
+
+var (
+	// _dnsHijack holds DNS destinations answered by local DNS server.
+	_dnsHijack atomic.Value
+
+	// _dnsHijacks limits hijacked UDP queries being answered.
+	_dnsHijacks = make(chan struct{}, maxDNSHijacks)
+)
+
+func init() {
+	_dnsHijack.Store([]hijackEntry(nil))
+}
+
+type hijackEntry struct {
+	network adapter.Network
+	ip      net.IP /* nil means any address */
+	port    uint16
+}
+
+func (e hijackEntry) match(metadata *adapter.Metadata) bool {
+	return e.network == metadata.Net && e.port == metadata.DstPort &&
+		(e.ip == nil || e.ip.Equal(metadata.DstIP))
+}
+
+// SetDNSHijack sets DNS destinations to hijack, e.g.
+// "udp://0.0.0.0:53,tcp://any:53", an empty string disables it.
+func SetDNSHijack(s string) error {
+	var entries []hijackEntry
+	for _, raw := range strings.Split(s, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+
+		entry, err := parseHijackEntry(raw)
+		if err != nil {
+			return fmt.Errorf("invalid DNS hijack %s: %w", raw, err)
+		}
+		entries = append(entries, entry)
+	}
+	_dnsHijack.Store(entries)
+	return nil
+}
+
+func parseHijackEntry(raw string) (hijackEntry, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "udp://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return hijackEntry{}, err
+	}
+
+	var entry hijackEntry
+	switch strings.ToLower(u.Scheme) {
+	case "udp":
+		entry.network = adapter.UDP
+	case "tcp":
+		entry.network = adapter.TCP
+	default:
+		return hijackEntry{}, fmt.Errorf("unsupported scheme: %s", u.Scheme)
+	}
+
+	entry.port = dnsDefaultPort
+	if p := u.Port(); p != "" {
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return hijackEntry{}, err
+		}
+		entry.port = uint16(port)
+	}
+
+	switch host := u.Hostname(); strings.ToLower(host) {
+	case "", "any":
+	default:
+		if entry.ip = net.ParseIP(host); entry.ip == nil {
+			return hijackEntry{}, fmt.Errorf("invalid IP: %s", host)
+		}
+		if entry.ip.IsUnspecified() {
+			entry.ip = nil
+		}
+	}
+	return entry, nil
+}
+
+func shouldHijackDNS(metadata *adapter.Metadata) bool {
+	for _, entry := range _dnsHijack.Load().([]hijackEntry) {
+		if entry.match(metadata) {
+			return true
+		}
+	}
+	return redirectDNSLeak(metadata)
+}
+
+// hijackUDP answers DNS query by local DNS server without
+// blocking the caller, the reply is written back with the
+// original destination as source. The query is dropped if too
+// many queries are being answered.
+func hijackUDP(packet adapter.UDPPacket) {
+	select {
+	case _dnsHijacks <- struct{}{}:
+	default:
+		log.Warnf("[DNS] hijack %s dropped: too many queries", packet.Metadata().SourceAddress())
+		packet.Drop()
+		return
+	}
+
+	go func() {
+		defer func() { <-_dnsHijacks }()
+		answerUDP(packet)
+	}()
+}
+
+func answerUDP(packet adapter.UDPPacket) {
+	defer packet.Drop()
+
+	metadata := packet.Metadata()
+	reply, err := dns.Exchange(packet.Data(), false /* UDP */)
+	if err != nil {
+		log.Warnf("[DNS] hijack %s error: %v", metadata.DestinationAddress(), err)
+		return
+	}
+
+	if _, err := packet.WriteBack(reply, nil); err != nil {
+		log.Warnf("[DNS] write back to %s error: %v", metadata.SourceAddress(), err)
+		return
+	}
+
+	log.Infof("[DNS] hijack %s --> %s", metadata.SourceAddress(), metadata.DestinationAddress())
+}
+
+// hijackTCP answers length-prefixed DNS queries on conn by
+// local DNS server until client closes the connection.
+func hijackTCP(conn adapter.TCPConn) {
+	metadata := conn.Metadata()
+	if err := establish(conn); err != nil {
+		log.Warnf("[DNS] establish %s error: %v", metadata.SourceAddress(), err)
+		return
+	}
+	log.Infof("[DNS] hijack %s <--> %s", metadata.SourceAddress(), metadata.DestinationAddress())
+
+	var length [2]byte
+	for {
+		conn.SetReadDeadline(time.Now().Add(dnsTCPTimeout))
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+
+		reply, err := dns.Exchange(msg, true /* TCP */)
+		if err != nil {
+			log.Warnf("[DNS] hijack %s error: %v", metadata.DestinationAddress(), err)
+			return
+		}
+
+		buf := make([]byte, 2+len(reply))
+		binary.BigEndian.PutUint16(buf, uint16(len(reply)))
+		copy(buf[2:], reply)
+		if _, err := conn.Write(buf); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/clash/common/cache"
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/rule"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+// Modes of DNS leak policy.
+const (
+	// DNSLeakBlock rejects DoT, DoH and plain DNS flows which
+	// aren't answered by local DNS server.
+	DNSLeakBlock = "block"
+
+	// DNSLeakRedirect rejects DoT and DoH flows, and answers
+	// plain DNS queries to any server by local DNS server.
+	DNSLeakRedirect = "redirect"
+)
+
+// Kinds of DNS leaks.
+const (
+	DNSLeakPlain = "dns"
+	DNSLeakDoT   = "dot"
+	DNSLeakDoH   = "doh"
+)
+
+const (
+	dotPort = 853
+	dohPort = 443
+
+	// DefaultDoHIPs are addresses of well-known DoH servers.
+	DefaultDoHIPs = "1.1.1.1,1.0.0.1,8.8.8.8,8.8.4.4,9.9.9.9,149.112.112.112," +
+		"208.67.222.222,208.67.220.220,94.140.14.14,94.140.15.15," +
+		"2606:4700:4700::1111,2606:4700:4700::1001,2001:4860:4860::8888,2001:4860:4860::8844"
+
+	// DefaultDoHDomains are domains of well-known DoH servers,
+	// their subdomains are matched too.
+	DefaultDoHDomains = "cloudflare-dns.com,dns.google,dns.quad9.net,doh.opendns.com," +
+		"dns.adguard.com,dns.nextdns.io,doh.dns.sb"
+)
+
+// dnsLeakPolicy detects DNS flows bypassing local DNS server,
+// DoT by port and DoH by destination IP or domain.
+type dnsLeakPolicy struct {
+	mode    string
+	ips     map[string]struct{}
+	domains *rule.DomainSet
+
+	rejected *cache.LruCache /* flowID -> struct{} */
+}
+
+var (
+	_dnsLeak atomic.Value
+
+	// _dnsLeakBlocked counts blocked DNS leaks by kind.
+	_dnsLeakBlocked = map[string]*atomic.Int64{
+		DNSLeakPlain: atomic.NewInt64(0),
+		DNSLeakDoT:   atomic.NewInt64(0),
+		DNSLeakDoH:   atomic.NewInt64(0),
+	}
+)
+
+func init() {
+	_dnsLeak.Store(&dnsLeakPolicy{})
+}
+
+// DNSLeakStatus reports DNS leak policy and blocked flows.
+type DNSLeakStatus struct {
+	Mode    string           `json:"mode"`
+	Blocked map[string]int64 `json:"blocked"`
+}
+
+// SetDNSLeakPolicy sets mode of DNS leak policy, DoH servers are
+// detected by IPs, e.g. "1.1.1.1,8.8.8.8", or by domains found
+// by fake DNS or sniffing, e.g. "dns.google". An empty mode
+// disables the policy.
+func SetDNSLeakPolicy(mode, ips, domains string) error {
+	p := &dnsLeakPolicy{
+		mode:     strings.ToLower(mode),
+		ips:      make(map[string]struct{}),
+		rejected: newFlowVerdicts(),
+	}
+	switch p.mode {
+	case "", DNSLeakBlock, DNSLeakRedirect:
+	default:
+		return fmt.Errorf("unsupported DNS leak mode: %s", mode)
+	}
+
+	for _, raw := range strings.Split(ips, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return fmt.Errorf("invalid DoH IP: %s", raw)
+		}
+		p.ips[ip.String()] = struct{}{}
+	}
+
+	ds, err := rule.ParseDomainSet(domains)
+	if err != nil {
+		return fmt.Errorf("invalid DoH domains: %w", err)
+	}
+	p.domains = ds
+
+	_dnsLeak.Store(p)
+	return nil
+}
+
+// GetDNSLeakStatus returns DNS leak policy and blocked flows.
+func GetDNSLeakStatus() DNSLeakStatus {
+	status := DNSLeakStatus{
+		Mode:    _dnsLeak.Load().(*dnsLeakPolicy).mode,
+		Blocked: make(map[string]int64, len(_dnsLeakBlocked)),
+	}
+	for kind, n := range _dnsLeakBlocked {
+		status.Blocked[kind] = n.Load()
+	}
+	return status
+}
+
+// redirectDNSLeak reports whether plain DNS query of metadata
+// should be answered by local DNS server.
+func redirectDNSLeak(metadata *adapter.Metadata) bool {
+	p := _dnsLeak.Load().(*dnsLeakPolicy)
+	return p.mode == DNSLeakRedirect && metadata.DstPort == dnsDefaultPort
+}
+
+// sniffDNSLeak reports whether flows to port should be sniffed
+// to detect DoH servers by domain.
+func sniffDNSLeak(port uint16) bool {
+	p := _dnsLeak.Load().(*dnsLeakPolicy)
+	return p.mode != "" && p.domains.Len() > 0 && port == dohPort
+}
+
+// blockDNSLeak reports whether the flow of metadata leaks DNS
+// queries and should be rejected. It's called by stack for each
+// UDP packet before queueing, so UDP flows are checked by ports,
+// IPs and domains found on routing before, their domains are
+// checked by blockDNSLeakHost on routing. UDP flows are counted
+// once, as later packets of rejected flows are not counted.
+func blockDNSLeak(metadata *adapter.Metadata) bool {
+	p := _dnsLeak.Load().(*dnsLeakPolicy)
+	if p.mode == "" {
+		return false
+	}
+
+	if metadata.Net != adapter.UDP {
+		return p.block(metadata, p.detect(metadata, metadata.Host))
+	}
+
+	id := newFlowID(metadata)
+	if p.rejected.Exist(id) {
+		return true
+	}
+	if !p.block(metadata, p.detect(metadata, "")) {
+		return false
+	}
+	p.rejected.Set(id, struct{}{})
+	return true
+}
+
+// blockDNSLeakHost reports whether the UDP flow of metadata to
+// host found on routing is a DNS leak, later packets of rejected
+// flows are rejected by blockDNSLeak.
+func blockDNSLeakHost(metadata *adapter.Metadata, host string) bool {
+	p := _dnsLeak.Load().(*dnsLeakPolicy)
+	if p.mode == "" || host == "" || !p.block(metadata, p.detect(metadata, host)) {
+		return false
+	}
+
+	p.rejected.Set(newFlowID(metadata), struct{}{})
+	return true
+}
+
+func (p *dnsLeakPolicy) block(metadata *adapter.Metadata, kind string) bool {
+	if kind == "" {
+		return false
+	}
+
+	_dnsLeakBlocked[kind].Inc()
+	log.Infof("[DNS] %s %s --> %s blocked", kind, metadata.SourceAddress(), metadata.DestinationAddress())
+	return true
+}
+
+// detect returns the kind of DNS leak of the flow to host, which
+// is empty if the domain is unknown.
+func (p *dnsLeakPolicy) detect(metadata *adapter.Metadata, host string) string {
+	switch metadata.DstPort {
+	case dnsDefaultPort:
+		/* hijacked queries are answered by local DNS server */
+		if p.mode == DNSLeakBlock && !shouldHijackDNS(metadata) {
+			return DNSLeakPlain
+		}
+	case dotPort:
+		return DNSLeakDoT
+	case dohPort:
+		if _, ok := p.ips[metadata.DstIP.String()]; ok {
+			return DNSLeakDoH
+		}
+		if p.domains.Match(host) {
+			return DNSLeakDoH
+		}
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/proxy"
+)
+
+const (
+	earlyDataBufferSize = 4 * 1024
+	maxEarlyDataWait    = time.Second
+)
+
+// _earlyDataWait is the time to wait for the first bytes of
+// client to send with proxy handshake, zero to disable.
+var _earlyDataWait = atomic.NewDuration(0)
+
+// SetEarlyDataWait sets the time to wait for early data of TCP
+// connections, zero to disable.
+func SetEarlyDataWait(d time.Duration) error {
+	if d < 0 || d > maxEarlyDataWait {
+		return fmt.Errorf("early data wait %s out of range [0, %s]", d, maxEarlyDataWait)
+	}
+	_earlyDataWait.Store(d)
+	return nil
+}
+
+// readEarlyData returns the first bytes of conn to be sent by
+// dialer, the returned conn doesn't replay them. Bytes peeked
+// by sniffer are used without waiting. Errors other than timeout
+// of the wait and EOF are returned, as the client may half-close
+// after sending its request, whose FIN is passed on by relay.
+func readEarlyData(conn adapter.TCPConn) (adapter.TCPConn, []byte, error) {
+	wait := _earlyDataWait.Load()
+	if wait == 0 || !proxy.SupportsEarlyData(conn.Metadata()) {
+		return conn, nil, nil
+	}
+
+	switch c := conn.(type) {
+	case *peekConn:
+		data := c.peeked
+		c.peeked = nil
+		return c, data, nil
+	case interface{ Establish() error }:
+		/* client sends nothing before deferred handshake */
+		return conn, nil, nil
+	}
+
+	buf := make([]byte, earlyDataBufferSize)
+	conn.SetReadDeadline(time.Now().Add(wait))
+	n, err := conn.Read(buf)
+	conn.SetReadDeadline(time.Time{})
+	if ne, ok := err.(net.Error); err != nil && !errors.Is(err, io.EOF) && !(ok && ne.Timeout()) {
+		return conn, nil, err
+	}
+	return conn, buf[:n], nil
+}
@@ -0,0 +1,312 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/manager"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+// defaultHookTimeout is the default deadline of each hook call.
+const defaultHookTimeout = time.Second
+
+// maxHookStragglers is the maximum number of hook calls which
+// timed out but are still running, as they can't be killed.
+const maxHookStragglers = 64
+
+// States of a hook call.
+const (
+	hookRunning int32 = iota
+	hookReturned
+	hookAbandoned
+)
+
+// Kinds of hook calls.
+const (
+	HookAccept = "accept"
+	HookDialed = "dialed"
+	HookClosed = "closed"
+)
+
+// Hook observes and controls lifecycle of flows, methods are
+// called with a context which is done after the hook timeout.
+// A call which doesn't return in time is abandoned, and its
+// flow is denied for OnAccept. Calls get a copy of metadata,
+// and hooks should return once ctx is done.
+type Hook interface {
+	// OnAccept is called after a flow is routed and before it's
+	// dialed, the returned verdict could deny the flow, override
+	// its outbound or attach tags to it.
+	OnAccept(ctx context.Context, metadata *adapter.Metadata) Verdict
+
+	// OnDialed is called after the upstream of a flow is dialed,
+	// closing conn closes the flow.
+	OnDialed(ctx context.Context, metadata *adapter.Metadata, conn DialedConn)
+
+	// OnClosed is called asynchronously after a flow is closed.
+	OnClosed(ctx context.Context, metadata *adapter.Metadata, stats manager.Stats)
+}
+
+// Verdict is the decision of Hook.OnAccept.
+type Verdict struct {
+	// Deny rejects the flow.
+	Deny bool
+
+	// Outbound overrides the routed outbound if it's not empty.
+	Outbound string
+
+	// Tags are merged into tags of metadata.
+	Tags map[string]string
+}
+
+// DialedConn is the upstream of a flow, it's net.Conn for TCP
+// and net.PacketConn for UDP.
+type DialedConn interface {
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// HookStatus reports latency of calls of a hook kind.
+type HookStatus struct {
+	Calls    int64  `json:"calls"`
+	Timeouts int64  `json:"timeouts"`
+	Panics   int64  `json:"panics"`
+	Average  string `json:"average"`
+	Max      string `json:"max"`
+}
+
+type hookStats struct {
+	calls    *atomic.Int64
+	timeouts *atomic.Int64
+	panics   *atomic.Int64
+	total    *atomic.Int64 /* nanoseconds */
+	max      *atomic.Int64 /* nanoseconds */
+}
+
+func newHookStats() *hookStats {
+	return &hookStats{
+		calls:    atomic.NewInt64(0),
+		timeouts: atomic.NewInt64(0),
+		panics:   atomic.NewInt64(0),
+		total:    atomic.NewInt64(0),
+		max:      atomic.NewInt64(0),
+	}
+}
+
+func (s *hookStats) record(d time.Duration) {
+	s.calls.Inc()
+	s.total.Add(int64(d))
+	for {
+		old := s.max.Load()
+		if int64(d) <= old || s.max.CAS(old, int64(d)) {
+			return
+		}
+	}
+}
+
+func (s *hookStats) status() HookStatus {
+	calls, total := s.calls.Load(), s.total.Load()
+	var avg time.Duration
+	if calls > 0 {
+		avg = time.Duration(total / calls)
+	}
+	return HookStatus{
+		Calls:    calls,
+		Timeouts: s.timeouts.Load(),
+		Panics:   s.panics.Load(),
+		Average:  avg.String(),
+		Max:      time.Duration(s.max.Load()).String(),
+	}
+}
+
+type hookResult struct {
+	value interface{}
+	ok    bool
+}
+
+// hookHolder is stored in _hook as atomic.Value doesn't
+// accept nil interface.
+type hookHolder struct {
+	hook    Hook
+	timeout time.Duration
+}
+
+var (
+	_hook           atomic.Value
+	_hookStragglers = atomic.NewInt32(0)
+	_hookStats      = map[string]*hookStats{
+		HookAccept: newHookStats(),
+		HookDialed: newHookStats(),
+		HookClosed: newHookStats(),
+	}
+)
+
+func init() {
+	_hook.Store(hookHolder{})
+}
+
+// SetHook sets hook of flows with timeout of each call, zero
+// timeout means default, nil hook removes the current one.
+func SetHook(hook Hook, timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("invalid hook timeout: %s", timeout)
+	}
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+
+	_hook.Store(hookHolder{hook: hook, timeout: timeout})
+	if hook == nil {
+		manager.DefaultManager.SetLeaveHook(nil)
+	} else {
+		manager.DefaultManager.SetLeaveHook(onClosed)
+	}
+	return nil
+}
+
+// GetHookStatus returns latency of hook calls by kind.
+func GetHookStatus() map[string]HookStatus {
+	status := make(map[string]HookStatus, len(_hookStats))
+	for kind, s := range _hookStats {
+		status[kind] = s.status()
+	}
+	return status
+}
+
+// callHook runs fn with a context of hook timeout, and returns
+// the result of fn and whether it returned in time without
+// panic. A call which doesn't return in time keeps running as a
+// straggler, no hook is called while there are too many of them.
+func callHook(kind string, timeout time.Duration, fn func(context.Context) interface{}) (interface{}, bool) {
+	stats := _hookStats[kind]
+
+	if _hookStragglers.Load() >= maxHookStragglers {
+		stats.timeouts.Inc()
+		log.Warnf("[HOOK] %s skipped: %d calls timed out still running", kind, maxHookStragglers)
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		state = atomic.NewInt32(hookRunning)
+		done  = make(chan hookResult, 1)
+		start = time.Now()
+	)
+	go func() {
+		var r hookResult
+		defer func() {
+			if p := recover(); p != nil {
+				stats.panics.Inc()
+				log.Errorf("[HOOK] %s panic: %v", kind, p)
+			}
+			if !state.CAS(hookRunning, hookReturned) {
+				_hookStragglers.Dec()
+				return
+			}
+			done <- r
+		}()
+		r = hookResult{value: fn(ctx), ok: true}
+	}()
+
+	select {
+	case r := <-done:
+		stats.record(time.Since(start))
+		return r.value, r.ok
+	case <-ctx.Done():
+		if !state.CAS(hookRunning, hookAbandoned) {
+			/* returned just in time */
+			r := <-done
+			stats.record(time.Since(start))
+			return r.value, r.ok
+		}
+		_hookStragglers.Inc()
+		stats.record(timeout)
+		stats.timeouts.Inc()
+		log.Warnf("[HOOK] %s timed out after %s", kind, timeout)
+		return nil, false
+	}
+}
+
+// onAccept calls OnAccept of hook and applies the verdict to
+// metadata, it reports whether the flow is allowed.
+func onAccept(metadata *adapter.Metadata) bool {
+	h := _hook.Load().(hookHolder)
+	if h.hook == nil {
+		return true
+	}
+
+	m := cloneMetadata(metadata)
+	r, ok := callHook(HookAccept, h.timeout, func(ctx context.Context) interface{} {
+		return h.hook.OnAccept(ctx, m)
+	})
+	if !ok {
+		return false
+	}
+
+	v := r.(Verdict)
+	if v.Deny {
+		return false
+	}
+	if v.Outbound != "" {
+		metadata.Outbound = v.Outbound
+	}
+	if len(v.Tags) > 0 {
+		if metadata.Tags == nil {
+			metadata.Tags = make(map[string]string, len(v.Tags))
+		}
+		for k, val := range v.Tags {
+			metadata.Tags[k] = val
+		}
+	}
+	return true
+}
+
+// onDialed calls OnDialed of hook with the upstream conn.
+func onDialed(metadata *adapter.Metadata, conn DialedConn) {
+	h := _hook.Load().(hookHolder)
+	if h.hook == nil {
+		return
+	}
+
+	m := cloneMetadata(metadata)
+	callHook(HookDialed, h.timeout, func(ctx context.Context) interface{} {
+		h.hook.OnDialed(ctx, m, conn)
+		return nil
+	})
+}
+
+// onClosed calls OnClosed of hook without blocking the caller,
+// which may be the manager closing idle connections.
+func onClosed(metadata *adapter.Metadata, stats manager.Stats) {
+	h := _hook.Load().(hookHolder)
+	if h.hook == nil {
+		return
+	}
+
+	m := cloneMetadata(metadata)
+	go callHook(HookClosed, h.timeout, func(ctx context.Context) interface{} {
+		h.hook.OnClosed(ctx, m, stats)
+		return nil
+	})
+}
+
+// cloneMetadata copies metadata for hooks, so calls running
+// after timed out don't race with the flow.
+func cloneMetadata(metadata *adapter.Metadata) *adapter.Metadata {
+	m := *metadata
+	if metadata.Tags != nil {
+		m.Tags = make(map[string]string, len(metadata.Tags))
+		for k, v := range metadata.Tags {
+			m.Tags[k] = v
+		}
+	}
+	return &m
+}
@@ -0,0 +1,276 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/dns"
+	"github.com/xjasonlyu/tun2socks/internal/manager"
+	"github.com/xjasonlyu/tun2socks/internal/proxy"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+const (
+	// DefaultHealthCheckURL is requested through the proxy to
+	// check its health, any HTTP response means healthy.
+	DefaultHealthCheckURL = "http://www.gstatic.com/generate_204"
+
+	// DefaultHealthCheckInterval is the default interval of
+	// health checks.
+	DefaultHealthCheckInterval = 10 * time.Second
+
+	// healthCheckFailures is the number of consecutive failed
+	// checks to engage kill switch.
+	healthCheckFailures = 3
+
+	minHealthCheckInterval = time.Second
+	maxHealthCheckTimeout  = 5 * time.Second
+)
+
+var (
+	// _killSwitchEngaged reports whether all flows are rejected
+	// because the proxy is unhealthy.
+	_killSwitchEngaged = atomic.NewBool(false)
+
+	_killSwitch killSwitch
+
+	errFallbackDialed = errors.New("dialed by fallback outbound")
+)
+
+// KillSwitchStatus reports state of kill switch.
+type KillSwitchStatus struct {
+	Enabled   bool      `json:"enabled"`
+	Engaged   bool      `json:"engaged"`
+	URL       string    `json:"url,omitempty"`
+	Failures  int       `json:"failures"`
+	LastCheck time.Time `json:"lastCheck"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// killSwitch checks health of the proxy periodically, and
+// rejects all flows while it's unhealthy.
+type killSwitch struct {
+	mu sync.Mutex
+
+	enabled   bool
+	done      chan struct{}
+	url       string
+	failures  int
+	lastCheck time.Time
+	lastError string
+}
+
+// StartKillSwitch checks health of the proxy by requesting rawURL
+// through it every interval. After consecutive failures, new flows
+// are rejected, existing flows are closed and the local DNS server
+// is paused; they're resumed once a check succeeds. It fails closed
+// until the first check succeeds.
+func StartKillSwitch(rawURL string, interval time.Duration) error {
+	if rawURL == "" {
+		rawURL = DefaultHealthCheckURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported health check URL: %s", rawURL)
+	}
+
+	if interval == 0 {
+		interval = DefaultHealthCheckInterval
+	}
+	if interval < minHealthCheckInterval {
+		return fmt.Errorf("health check interval %s less than %s", interval, minHealthCheckInterval)
+	}
+
+	ks := &_killSwitch
+	ks.mu.Lock()
+	if ks.enabled {
+		ks.mu.Unlock()
+		return errors.New("kill switch already started")
+	}
+	ks.enabled, ks.url = true, rawURL
+	ks.done = make(chan struct{})
+	done := ks.done
+	ks.mu.Unlock()
+
+	engageKillSwitch("waiting for first health check")
+
+	timeout := interval
+	if timeout > maxHealthCheckTimeout {
+		timeout = maxHealthCheckTimeout
+	}
+	go ks.run(rawURL, interval, timeout, done)
+	return nil
+}
+
+// StopKillSwitch stops health checks of kill switch, it keeps
+// rejecting flows if it's engaged, as it's used on shutdown.
+func StopKillSwitch() {
+	ks := &_killSwitch
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if !ks.enabled {
+		return
+	}
+	close(ks.done)
+	ks.enabled, ks.done = false, nil
+}
+
+// KillSwitchEngaged reports whether flows are rejected by kill switch.
+func KillSwitchEngaged() bool {
+	return _killSwitchEngaged.Load()
+}
+
+// GetKillSwitchStatus returns current state of kill switch.
+func GetKillSwitchStatus() KillSwitchStatus {
+	ks := &_killSwitch
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	return KillSwitchStatus{
+		Enabled:   ks.enabled,
+		Engaged:   KillSwitchEngaged(),
+		URL:       ks.url,
+		Failures:  ks.failures,
+		LastCheck: ks.lastCheck,
+		LastError: ks.lastError,
+	}
+}
+
+func (ks *killSwitch) run(rawURL string, interval, timeout time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := checkHealth(rawURL, timeout)
+
+		ks.mu.Lock()
+		select {
+		case <-done: /* stopped while checking */
+			ks.mu.Unlock()
+			return
+		default:
+		}
+		ks.lastCheck = time.Now()
+		if err != nil {
+			ks.failures++
+			ks.lastError = err.Error()
+		} else {
+			ks.failures = 0
+			ks.lastError = ""
+		}
+		failures := ks.failures
+		ks.mu.Unlock()
+
+		switch {
+		case err == nil:
+			resumeKillSwitch()
+		case failures >= healthCheckFailures:
+			engageKillSwitch(err.Error())
+		default:
+			log.Warnf("[KILL] health check failed (%d/%d): %v", failures, healthCheckFailures, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// engageKillSwitch rejects new flows, closes existing flows and
+// pauses local DNS server so no query leaks to nameservers.
+func engageKillSwitch(reason string) {
+	if !_killSwitchEngaged.CAS(false, true) {
+		return
+	}
+	log.Warnf("[KILL] engaged: %s", reason)
+
+	closed := manager.DefaultManager.CloseAll(manager.ReasonKillSwitch)
+	if closed > 0 {
+		log.Warnf("[KILL] %d flows closed", closed)
+	}
+	if err := dns.Pause(); err != nil {
+		log.Warnf("[KILL] pause DNS server error: %v", err)
+	}
+}
+
+// resumeKillSwitch resumes forwarding after health returns.
+func resumeKillSwitch() {
+	if !_killSwitchEngaged.Load() {
+		return
+	}
+	if err := dns.Resume(); err != nil {
+		log.Warnf("[KILL] resume DNS server error: %v", err)
+	}
+	_killSwitchEngaged.Store(false)
+	log.Infof("[KILL] disengaged: proxy is healthy")
+}
+
+// checkHealth requests rawURL through the proxy. Dials served by
+// fallback outbound don't count, as they bypass the proxy.
+func checkHealth(rawURL string, timeout time.Duration) error {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext:       dialHealthCheck,
+			DisableKeepAlives: true,
+		},
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func dialHealthCheck(ctx context.Context, network, address string) (net.Conn, error) {
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &adapter.Metadata{
+		Net:      adapter.TCP,
+		DstPort:  uint16(port),
+		Outbound: proxy.OutboundProxy,
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		metadata.DstIP = ip
+	} else {
+		metadata.Host = host /* resolved by proxy */
+	}
+
+	c, err := proxy.DialContext(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Outbound != proxy.OutboundProxy {
+		c.Close()
+		return nil, errFallbackDialed
+	}
+	return c, nil
+}
@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"fmt"
+	"sync"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+// Limits of concurrent connections, zero means unlimited.
+type Limits struct {
+	Total          int `json:"total"`
+	PerSource      int `json:"perSource"`
+	PerDestination int `json:"perDestination"`
+}
+
+// LimitStatus reports limits, active and rejected connections.
+type LimitStatus struct {
+	Limits   Limits           `json:"limits"`
+	Active   int              `json:"active"`
+	Rejected map[string]int64 `json:"rejected"`
+}
+
+const (
+	limitTotal       = "total"
+	limitSource      = "source"
+	limitDestination = "destination"
+)
+
+var _limiter = &limiter{
+	bySource:      make(map[string]int),
+	byDestination: make(map[string]int),
+	rejected: map[string]*atomic.Int64{
+		limitTotal:       atomic.NewInt64(0),
+		limitSource:      atomic.NewInt64(0),
+		limitDestination: atomic.NewInt64(0),
+	},
+}
+
+type limiter struct {
+	mu            sync.Mutex
+	limits        Limits
+	active        int
+	bySource      map[string]int
+	byDestination map[string]int
+
+	rejected map[string]*atomic.Int64
+}
+
+// SetLimits sets limits of concurrent TCP connections and
+// UDP sessions, applied to new flows before dialing.
+func SetLimits(limits Limits) error {
+	if limits.Total < 0 || limits.PerSource < 0 || limits.PerDestination < 0 {
+		return fmt.Errorf("invalid limits: %+v", limits)
+	}
+
+	_limiter.mu.Lock()
+	_limiter.limits = limits
+	_limiter.mu.Unlock()
+	return nil
+}
+
+// GetLimitStatus returns status of connection limits.
+func GetLimitStatus() LimitStatus {
+	l := _limiter
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rejected := make(map[string]int64, len(l.rejected))
+	for k, v := range l.rejected {
+		rejected[k] = v.Load()
+	}
+	return LimitStatus{Limits: l.limits, Active: l.active, Rejected: rejected}
+}
+
+// acquire takes a slot for metadata and returns the function
+// to release it, or the name of limit reached if rejected.
+func (l *limiter) acquire(metadata *adapter.Metadata) (func(), string) {
+	src, dst := metadata.SrcIP.String(), metadata.DestinationAddress()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var reason string
+	switch {
+	case l.limits.Total > 0 && l.active >= l.limits.Total:
+		reason = limitTotal
+	case l.limits.PerSource > 0 && l.bySource[src] >= l.limits.PerSource:
+		reason = limitSource
+	case l.limits.PerDestination > 0 && l.byDestination[dst] >= l.limits.PerDestination:
+		reason = limitDestination
+	}
+
+	if reason != "" {
+		l.rejected[reason].Inc()
+		return nil, reason
+	}
+
+	l.active++
+	l.bySource[src]++
+	l.byDestination[dst]++
+
+	var once sync.Once
+	return func() { once.Do(func() { l.release(src, dst) }) }, ""
+}
+
+func (l *limiter) release(src, dst string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	if l.bySource[src]--; l.bySource[src] <= 0 {
+		delete(l.bySource, src)
+	}
+	if l.byDestination[dst]--; l.byDestination[dst] <= 0 {
+		delete(l.byDestination, dst)
+	}
+}
@@ -0,0 +1,203 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/clash/common/pool"
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/sniffer"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+const (
+	sniffTimeout    = 300 * time.Millisecond
+	sniffBufferSize = 8 * 1024
+
+	// maxSniffDatagrams is the maximum number of datagrams
+	// waited for QUIC ClientHello after the first one.
+	maxSniffDatagrams = 4
+)
+
+// _sniffPorts holds destination port ranges to sniff.
+var _sniffPorts atomic.Value
+
+func init() {
+	_sniffPorts.Store(portRanges(nil))
+}
+
+// SetSniffPorts enables domain sniffing on destination ports,
+// e.g. "80,443,8000-8080", an empty string disables it.
+func SetSniffPorts(s string) error {
+	ports, err := parsePortRanges(s)
+	if err != nil {
+		return err
+	}
+	_sniffPorts.Store(ports)
+	return nil
+}
+
+// sniffTCP peeks at the first bytes sent by the client and
+// fills metadata.Host with the domain found in TLS SNI or
+// HTTP Host header. The returned conn replays peeked bytes.
+func sniffTCP(conn adapter.TCPConn) adapter.TCPConn {
+	metadata := conn.Metadata()
+	if metadata.Host != "" || !(_sniffPorts.Load().(portRanges).contains(metadata.DstPort) || sniffDNSLeak(metadata.DstPort)) {
+		return conn
+	}
+
+	// clients send nothing before the handshake, so a deferred
+	// handshake is completed before dialing on sniffed ports,
+	// and on DoH port if DNS leak policy matches DoH domains.
+	if err := establish(conn); err != nil {
+		return conn
+	}
+
+	buf := pool.Get(sniffBufferSize)
+	defer pool.Put(buf)
+
+	var (
+		n    int
+		host string
+		err  error
+	)
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	for n < len(buf) {
+		var nr int
+		nr, err = conn.Read(buf[n:])
+		n += nr
+		if err != nil {
+			break
+		}
+
+		if host, err = sniffer.SniffTCP(buf[:n]); !errors.Is(err, sniffer.ErrNoClue) {
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if host != "" {
+		metadata.Host = host
+		log.Debugf("[Sniffer] %s sniffed %s", metadata.DestinationAddress(), host)
+	}
+
+	if n == 0 {
+		return conn
+	}
+
+	peeked := make([]byte, n)
+	copy(peeked, buf[:n])
+	return &peekConn{TCPConn: conn, peeked: peeked}
+}
+
+// sniffUDP returns the domain of the flow of packet, found by
+// fake DNS or in QUIC ClientHello if domains are matched by DNS
+// leak policy or UDP block. Sniffed domain fills metadata.Host on
+// sniffed ports only, which is reported by sniffed. If ClientHello
+// spans several datagrams, the next ones of the flow are received
+// from more for a while.
+func sniffUDP(packet adapter.UDPPacket, more <-chan []byte) (host string, sniffed bool) {
+	metadata := packet.Metadata()
+	if metadata.Host != "" {
+		return metadata.Host, false
+	}
+
+	fill := _sniffPorts.Load().(portRanges).contains(metadata.DstPort)
+	if !(fill || sniffDNSLeak(metadata.DstPort) || blockUDPDomains()) {
+		return "", false
+	}
+
+	host, err := sniffer.SniffQUIC(packet.Data())
+	if errors.Is(err, sniffer.ErrNoClue) {
+		timer := time.NewTimer(sniffTimeout)
+		defer timer.Stop()
+
+		for i := 0; i < maxSniffDatagrams && errors.Is(err, sniffer.ErrNoClue); i++ {
+			select {
+			case b := <-more:
+				host, err = sniffer.SniffQUIC(b)
+			case <-timer.C:
+				return "", false
+			}
+		}
+	}
+	if err != nil {
+		return "", false
+	}
+
+	if fill {
+		metadata.Host = host
+		log.Debugf("[Sniffer] %s sniffed %s", metadata.DestinationAddress(), host)
+	}
+	return host, fill
+}
+
+// peekConn replays peeked bytes before reading from the conn.
+type peekConn struct {
+	adapter.TCPConn
+
+	peeked []byte
+}
+
+func (pc *peekConn) Read(b []byte) (int, error) {
+	if len(pc.peeked) > 0 {
+		n := copy(b, pc.peeked)
+		pc.peeked = pc.peeked[n:]
+		return n, nil
+	}
+	return pc.TCPConn.Read(b)
+}
+
+func (pc *peekConn) Abort() {
+	abort(pc.TCPConn)
+}
+
+func (pc *peekConn) CloseWrite() error {
+	return closeWrite(pc.TCPConn)
+}
+
+type portRange struct {
+	from uint16
+	to   uint16
+}
+
+type portRanges []portRange
+
+func (pr portRanges) contains(port uint16) bool {
+	for _, r := range pr {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePortRanges parses comma-separated ports and port ranges.
+func parsePortRanges(s string) (portRanges, error) {
+	var ranges portRanges
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+
+		parts := strings.SplitN(p, "-", 2)
+		from, err := strconv.ParseUint(parts[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %s", p)
+		}
+
+		to := from
+		if len(parts) == 2 {
+			if to, err = strconv.ParseUint(parts[1], 10, 16); err != nil || to < from {
+				return nil, fmt.Errorf("invalid port range: %s", p)
+			}
+		}
+		ranges = append(ranges, portRange{uint16(from), uint16(to)})
+	}
+	return ranges, nil
+}
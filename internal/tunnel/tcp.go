@@ -1,12 +1,16 @@
 package tunnel
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net"
 	"strconv"
 	"sync"
 	"time"
 
+	"go.uber.org/atomic"
+
 	"github.com/xjasonlyu/clash/common/pool"
 	"github.com/xjasonlyu/tun2socks/internal/adapter"
 	"github.com/xjasonlyu/tun2socks/internal/manager"
@@ -14,10 +18,7 @@ import (
 	"github.com/xjasonlyu/tun2socks/pkg/log"
 )
 
-const (
-	tcpWaitTimeout  = 5 * time.Second
-	relayBufferSize = pool.RelayBufferSize
-)
+const relayBufferSize = pool.RelayBufferSize
 
 func handleTCP(localConn adapter.TCPConn) {
 	defer localConn.Close()
@@ -28,15 +29,77 @@ func handleTCP(localConn adapter.TCPConn) {
 		return
 	}
 
+	if !_accepting.Load() || KillSwitchEngaged() {
+		abort(localConn)
+		return
+	}
+
+	if shouldHijackDNS(metadata) {
+		hijackTCP(localConn)
+		return
+	}
+
 	err := resolveMetadata(metadata)
 	if err != nil {
 		log.Warnf("[Metadata] resolve metadata error: %v", err)
 		return
 	}
+	dnat := rewriteDestination(metadata)
+
+	release, reason := _limiter.acquire(metadata)
+	if release == nil {
+		log.Warnf("[TCP] %s --> %s rejected: %s limit reached", metadata.SourceAddress(), metadata.DestinationAddress(), reason)
+		abort(localConn)
+		return
+	}
+	defer release()
+
+	if dnat == nil { /* keep the DNAT target */
+		localConn = sniffTCP(localConn)
+	}
+	if blockDNSLeak(metadata) {
+		abort(localConn)
+		return
+	}
+	lookupProcess(metadata)
+	routeMetadata(metadata)
+	routeDNAT(metadata, dnat)
+	applyTimeout(metadata)
+
+	if !onAccept(metadata) {
+		log.Infof("[TCP] %s --> %s denied by hook", metadata.SourceAddress(), metadata.DestinationAddress())
+		abort(localConn)
+		return
+	}
 
-	targetConn, err := proxy.Dial(metadata)
+	localConn, earlyData, err := readEarlyData(localConn)
+	if err != nil {
+		log.Warnf("[TCP] %s --> %s read early data error: %v", metadata.SourceAddress(), metadata.DestinationAddress(), err)
+		return
+	}
+
+	// kill switch may engage while sniffing, calling hooks or
+	// waiting for early data, and the flow may be routed to an
+	// outbound bypassing the proxy, so check it again.
+	if KillSwitchEngaged() {
+		abort(localConn)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), metadata.Timeout.Connect)
+	defer cancel()
+
+	start := time.Now()
+	targetConn, err := proxy.DialContextWithEarlyData(ctx, metadata, earlyData)
 	if err != nil {
 		log.Warnf("[TCP] dial %s error: %v", metadata.DestinationAddress(), err)
+		logDialError(metadata, start, err)
+		return
+	}
+
+	if err := establish(localConn); err != nil {
+		log.Warnf("[TCP] establish %s error: %v", metadata.SourceAddress(), err)
+		targetConn.Close()
 		return
 	}
 
@@ -50,37 +113,100 @@ func handleTCP(localConn adapter.TCPConn) {
 		metadata.MidPort = uint16(port)
 	}
 
-	targetConn = manager.NewTCPTracker(targetConn, metadata)
+	tracker := manager.NewTCPTracker(targetConn, metadata)
+	tracker.PushUploaded(int64(len(earlyData)))
+	targetConn = tracker
 	defer targetConn.Close()
 
-	log.Infof("[TCP] %s <--> %s", metadata.SourceAddress(), metadata.DestinationAddress())
-	relay(localConn, targetConn) /* relay connections */
+	if KillSwitchEngaged() { /* engaged before tracked */
+		return
+	}
+
+	onDialed(metadata, targetConn)
+
+	log.Infof("[TCP] %s <--> %s via %s", metadata.SourceAddress(), metadata.DestinationAddress(), metadata.Outbound)
+	relay(localConn, targetConn, metadata.Timeout.Wait) /* relay connections */
 }
 
 // relay copies between left and right bidirectionally.
-func relay(left, right net.Conn) {
+func relay(left, right net.Conn, wait time.Duration) {
 	var wg sync.WaitGroup
 	wg.Add(2)
 
+	leftIdle, rightIdle := atomic.NewBool(false), atomic.NewBool(false)
 	go func() {
 		defer wg.Done()
-		_ = copyBuffer(right, left) /* ignore error */
-		right.SetReadDeadline(time.Now().Add(tcpWaitTimeout))
+		pipe(right, left, wait, rightIdle, leftIdle)
 	}()
 
 	go func() {
 		defer wg.Done()
-		_ = copyBuffer(left, right) /* ignore error */
-		left.SetReadDeadline(time.Now().Add(tcpWaitTimeout))
+		pipe(left, right, wait, leftIdle, rightIdle)
 	}()
 
 	wg.Wait()
 }
 
-func copyBuffer(dst io.Writer, src io.Reader) error {
+// pipe copies src to dst. EOF of src is propagated to dst as
+// FIN, then the other direction times out after idle for wait,
+// its deadline is extended by each read once dstIdle is set;
+// on error, the other direction is aborted immediately.
+func pipe(dst, src net.Conn, wait time.Duration, dstIdle, srcIdle *atomic.Bool) {
+	if err := copyBuffer(dst, src, wait, srcIdle); err != nil {
+		dst.SetReadDeadline(time.Now())
+		return
+	}
+
+	_ = closeWrite(dst) /* ignore error */
+	dstIdle.Store(true)
+	dst.SetReadDeadline(time.Now().Add(wait))
+}
+
+// abort resets conn if it's supported.
+func abort(conn net.Conn) {
+	if a, ok := conn.(interface{ Abort() }); ok {
+		a.Abort()
+	}
+}
+
+// establish completes the deferred handshake of conn if
+// it's supported.
+func establish(conn net.Conn) error {
+	if e, ok := conn.(interface{ Establish() error }); ok {
+		return e.Establish()
+	}
+	return nil
+}
+
+// closeWrite half-closes conn if it's supported.
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// copyBuffer copies src to dst until EOF, the read deadline of
+// src is extended by wait after each read if idle is set.
+func copyBuffer(dst io.Writer, src net.Conn, wait time.Duration, idle *atomic.Bool) error {
 	buf := pool.Get(relayBufferSize)
 	defer pool.Put(buf)
 
-	_, err := io.CopyBuffer(dst, src, buf)
-	return err
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if idle.Load() {
+				src.SetReadDeadline(time.Now().Add(wait))
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
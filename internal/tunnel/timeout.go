@@ -0,0 +1,157 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+)
+
+const (
+	tcpConnectTimeout = 5 * time.Second
+	tcpWaitTimeout    = 5 * time.Second
+	udpTimeout        = 30 * time.Second
+
+	minTimeout = 100 * time.Millisecond
+	maxTimeout = 24 * time.Hour
+)
+
+// Kinds of timeout could be overridden by destination port.
+const (
+	TimeoutTCPConnect = "tcp-connect"
+	TimeoutTCPWait    = "tcp-wait"
+	TimeoutUDP        = "udp"
+)
+
+var (
+	_tcpConnectTimeout = atomic.NewDuration(tcpConnectTimeout)
+
+	// _tcpWaitTimeout is the idle timeout of the other direction
+	// after one direction of relay is closed, it's a safety net
+	// for half-open connections.
+	_tcpWaitTimeout = atomic.NewDuration(tcpWaitTimeout)
+
+	// _udpTimeout is the idle timeout of UDP NAT entries.
+	_udpTimeout = atomic.NewDuration(udpTimeout)
+
+	// _timeoutOverrides holds timeouts of destination ports.
+	_timeoutOverrides atomic.Value
+)
+
+func init() {
+	_timeoutOverrides.Store([]timeoutOverride(nil))
+}
+
+type timeoutOverride struct {
+	kind    string
+	ports   portRanges
+	timeout time.Duration
+}
+
+func validateTimeout(t time.Duration) error {
+	if t < minTimeout || t > maxTimeout {
+		return fmt.Errorf("timeout %s out of range [%s, %s]", t, minTimeout, maxTimeout)
+	}
+	return nil
+}
+
+// SetTCPConnectTimeout sets the timeout of dialing TCP.
+func SetTCPConnectTimeout(t time.Duration) error {
+	if err := validateTimeout(t); err != nil {
+		return err
+	}
+	_tcpConnectTimeout.Store(t)
+	return nil
+}
+
+// SetTCPWaitTimeout sets the wait timeout of half-closed relay.
+func SetTCPWaitTimeout(t time.Duration) error {
+	if err := validateTimeout(t); err != nil {
+		return err
+	}
+	_tcpWaitTimeout.Store(t)
+	return nil
+}
+
+// SetUDPTimeout sets the idle timeout of UDP sessions.
+func SetUDPTimeout(t time.Duration) error {
+	if err := validateTimeout(t); err != nil {
+		return err
+	}
+	_udpTimeout.Store(t)
+	return nil
+}
+
+// SetTimeoutOverrides sets timeouts by destination port, in
+// the form of "kind/ports=timeout", e.g. "udp/3478=10m". The
+// first matched override takes effect.
+func SetTimeoutOverrides(raw []string) error {
+	var overrides []timeoutOverride
+	for _, s := range raw {
+		o, err := parseTimeoutOverride(s)
+		if err != nil {
+			return fmt.Errorf("invalid timeout override %s: %w", s, err)
+		}
+		overrides = append(overrides, o)
+	}
+	_timeoutOverrides.Store(overrides)
+	return nil
+}
+
+func parseTimeoutOverride(s string) (timeoutOverride, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return timeoutOverride{}, fmt.Errorf("missing timeout")
+	}
+
+	target := strings.SplitN(kv[0], "/", 2)
+	if len(target) != 2 {
+		return timeoutOverride{}, fmt.Errorf("missing port")
+	}
+
+	kind := strings.ToLower(strings.TrimSpace(target[0]))
+	switch kind {
+	case TimeoutTCPConnect, TimeoutTCPWait, TimeoutUDP:
+	default:
+		return timeoutOverride{}, fmt.Errorf("unsupported kind: %s", kind)
+	}
+
+	ports, err := parsePortRanges(target[1])
+	if err != nil {
+		return timeoutOverride{}, err
+	}
+
+	timeout, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+	if err != nil {
+		return timeoutOverride{}, err
+	}
+	if err := validateTimeout(timeout); err != nil {
+		return timeoutOverride{}, err
+	}
+
+	return timeoutOverride{kind: kind, ports: ports, timeout: timeout}, nil
+}
+
+func timeoutOf(kind string, port uint16, def time.Duration) time.Duration {
+	for _, o := range _timeoutOverrides.Load().([]timeoutOverride) {
+		if o.kind == kind && o.ports.contains(port) {
+			return o.timeout
+		}
+	}
+	return def
+}
+
+// applyTimeout fills metadata with the effective timeouts.
+func applyTimeout(metadata *adapter.Metadata) {
+	port := metadata.DstPort
+	switch metadata.Net {
+	case adapter.TCP:
+		metadata.Timeout.Connect = timeoutOf(TimeoutTCPConnect, port, _tcpConnectTimeout.Load())
+		metadata.Timeout.Wait = timeoutOf(TimeoutTCPWait, port, _tcpWaitTimeout.Load())
+	case adapter.UDP:
+		metadata.Timeout.Idle = timeoutOf(TimeoutUDP, port, _udpTimeout.Load())
+	}
+}
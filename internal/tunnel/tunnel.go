@@ -1,32 +1,75 @@
 package tunnel
 
 import (
+	"errors"
+	"hash/fnv"
 	"runtime"
 
+	"go.uber.org/atomic"
+
 	"github.com/xjasonlyu/tun2socks/internal/adapter"
 	"github.com/xjasonlyu/tun2socks/pkg/log"
 )
 
 const (
-	// maxUDPQueueSize is the max number of UDP packets
-	// could be buffered. if queue is full, upcoming packets
-	// would be dropped util queue is ready again.
-	maxUDPQueueSize = 2 << 10
+	// defaultUDPQueueSize is the default max number of UDP
+	// packets could be buffered by each queue. if queue is
+	// full, upcoming packets would be dropped util queue is
+	// ready again.
+	defaultUDPQueueSize = 2 << 10
 )
 
 var (
-	numUDPWorkers = max(runtime.NumCPU(), 4 /* at least 4 workers */)
+	// _accepting reports whether new flows are accepted,
+	// packets of existing UDP sessions are not affected.
+	_accepting = atomic.NewBool(true)
 
 	tcpQueue      = make(chan adapter.TCPConn) /* unbuffered */
-	udpMultiQueue = make([]chan adapter.UDPPacket, 0, numUDPWorkers)
+	udpMultiQueue []*udpQueue
 )
 
-func init() {
+type udpQueue struct {
+	packets chan adapter.UDPPacket
+	dropped *atomic.Int64
+}
+
+// QueueStatus reports depth and drops of a UDP queue.
+type QueueStatus struct {
+	Depth    int   `json:"depth"`
+	Capacity int   `json:"capacity"`
+	Dropped  int64 `json:"dropped"`
+}
+
+// Start starts TCP and UDP workers, a zero number of workers
+// or queue size means default value.
+func Start(numUDPWorkers, udpQueueSize int) error {
+	if numUDPWorkers < 0 || udpQueueSize < 0 {
+		return errors.New("invalid number of UDP workers or queue size")
+	}
+
+	if numUDPWorkers == 0 {
+		numUDPWorkers = max(runtime.NumCPU(), 4 /* at least 4 workers */)
+	}
+	if udpQueueSize == 0 {
+		udpQueueSize = defaultUDPQueueSize
+	}
+
+	udpMultiQueue = make([]*udpQueue, 0, numUDPWorkers)
 	for i := 0; i < numUDPWorkers; i++ {
-		udpMultiQueue = append(udpMultiQueue, make(chan adapter.UDPPacket, maxUDPQueueSize))
+		udpMultiQueue = append(udpMultiQueue, &udpQueue{
+			packets: make(chan adapter.UDPPacket, udpQueueSize),
+			dropped: atomic.NewInt64(0),
+		})
 	}
 
 	go process()
+	return nil
+}
+
+// StopAccepting stops accepting new TCP connections and UDP
+// sessions, it's used to drain active flows before exiting.
+func StopAccepting() {
+	_accepting.Store(false)
 }
 
 // Add adds tcpConn to tcpQueue.
@@ -34,26 +77,72 @@ func Add(conn adapter.TCPConn) {
 	tcpQueue <- conn
 }
 
-// AddPacket adds udpPacket to udpQueue.
-func AddPacket(packet adapter.UDPPacket) {
-	m := packet.Metadata()
+// AddPacket adds udpPacket to udpQueue, it returns false if
+// the packet is blocked, then stack replies ICMP port unreachable.
+func AddPacket(packet adapter.UDPPacket) bool {
+	if KillSwitchEngaged() || blockDNSLeak(packet.Metadata()) {
+		return false
+	}
+
+	if shouldBlockUDP(packet.Metadata()) {
+		_udpBlocked.Inc()
+		metadata := packet.Metadata()
+		log.Debugf("[UDP] %s --> %s blocked", metadata.SourceAddress(), metadata.DestinationAddress())
+		return false
+	}
+
 	// In order to keep each packet sent in order, we
 	// calculate which queue each packet should be sent
-	// by src/dst info, and make sure the rest of them
-	// would only be sent to the same queue.
-	i := int(m.SrcPort+m.DstPort) % numUDPWorkers
+	// by 5-tuple, and make sure the rest of them would
+	// only be sent to the same queue.
+	q := udpMultiQueue[hashFlow(packet.Metadata())%uint32(len(udpMultiQueue))]
 
 	select {
-	case udpMultiQueue[i] <- packet:
+	case q.packets <- packet:
 	default:
+		q.dropped.Inc()
 		log.Warnf("queue is currently full, packet will be dropped")
 		packet.Drop()
 	}
+	return true
+}
+
+// hashFlow returns FNV-1a hash of 5-tuple of metadata, mixed
+// by murmur3 finalizer as low bits of FNV-1a only depend on
+// low bits of input bytes.
+func hashFlow(m *adapter.Metadata) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte{byte(m.Net)})
+	h.Write(m.SrcIP)
+	h.Write([]byte{byte(m.SrcPort >> 8), byte(m.SrcPort)})
+	h.Write(m.DstIP)
+	h.Write([]byte{byte(m.DstPort >> 8), byte(m.DstPort)})
+
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// GetQueueStatus returns status of each UDP queue.
+func GetQueueStatus() []QueueStatus {
+	status := make([]QueueStatus, 0, len(udpMultiQueue))
+	for _, q := range udpMultiQueue {
+		status = append(status, QueueStatus{
+			Depth:    len(q.packets),
+			Capacity: cap(q.packets),
+			Dropped:  q.dropped.Load(),
+		})
+	}
+	return status
 }
 
 func process() {
-	for _, udpQueue := range udpMultiQueue {
-		queue := udpQueue
+	for _, q := range udpMultiQueue {
+		queue := q.packets
 		go func() {
 			for packet := range queue {
 				handleUDP(packet)
@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+)
+
+const (
+	benchFlows   = 1024
+	benchWorkers = 8
+)
+
+// skewedPackets returns n packets of benchFlows flows, the flows
+// follow zipf distribution so a few of them carry most packets.
+func skewedPackets(n int) []adapter.UDPPacket {
+	flows := make([]*testPacket, benchFlows)
+	for i := range flows {
+		flows[i] = &testPacket{
+			metadata: &adapter.Metadata{
+				Net:     adapter.UDP,
+				SrcIP:   net.IPv4(10, 0, byte(i>>8), byte(i)),
+				SrcPort: uint16(10000 + i),
+				DstIP:   net.IPv4(1, 1, 1, 1),
+				DstPort: 443,
+			},
+			data: make([]byte, 1200),
+		}
+	}
+
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.2, 1, benchFlows-1)
+	packets := make([]adapter.UDPPacket, n)
+	for i := range packets {
+		packets[i] = flows[zipf.Uint64()]
+	}
+	return packets
+}
+
+func BenchmarkHashFlow(b *testing.B) {
+	packets := skewedPackets(b.N)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		hashFlow(packets[i].Metadata())
+	}
+}
+
+// BenchmarkAddPacketSkewed dispatches packets of skewed flows to
+// worker queues, the share of the busiest queue is reported.
+func BenchmarkAddPacketSkewed(b *testing.B) {
+	packets := skewedPackets(b.N)
+
+	old := udpMultiQueue
+	defer func() { udpMultiQueue = old }()
+
+	/* drops of full queues are reported below */
+	log.SetLevel(log.ErrorLevel)
+	defer log.SetLevel(log.InfoLevel)
+
+	var wg sync.WaitGroup
+	counts := make([]*atomic.Int64, benchWorkers)
+	udpMultiQueue = make([]*udpQueue, benchWorkers)
+	for i := range udpMultiQueue {
+		q := &udpQueue{
+			packets: make(chan adapter.UDPPacket, defaultUDPQueueSize),
+			dropped: atomic.NewInt64(0),
+		}
+		udpMultiQueue[i], counts[i] = q, atomic.NewInt64(0)
+
+		wg.Add(1)
+		go func(count *atomic.Int64) {
+			defer wg.Done()
+			for range q.packets {
+				count.Inc()
+			}
+		}(counts[i])
+	}
+
+	b.SetBytes(1200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		AddPacket(packets[i])
+	}
+	b.StopTimer()
+
+	for _, q := range udpMultiQueue {
+		close(q.packets)
+	}
+	wg.Wait()
+
+	/* packets dispatched to each queue, handled or dropped */
+	var dropped, busiest int64
+	for i, q := range udpMultiQueue {
+		dropped += q.dropped.Load()
+		if n := counts[i].Load() + q.dropped.Load(); n > busiest {
+			busiest = n
+		}
+	}
+
+	b.ReportMetric(float64(busiest)/float64(b.N), "busiest-share")
+	b.ReportMetric(float64(dropped)/float64(b.N), "dropped-share")
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/xjasonlyu/clash/common/pool"
@@ -17,7 +18,6 @@ import (
 )
 
 const (
-	udpTimeout    = 30 * time.Second
 	udpBufferSize = (1 << 16) - 1 // largest possible UDP datagram
 )
 
@@ -27,6 +27,120 @@ var (
 	natTable = nat.NewTable()
 )
 
+// natEntry is the value of natTable, it relays flows from a
+// source routed to the same outbound.
+type natEntry struct {
+	net.PacketConn
+
+	// filter accepts replies from contacted endpoints only,
+	// domains are resolved once when contacted.
+	filter  *nat.Filter
+	domains sync.Map /* host:port -> struct{} */
+
+	// origins maps destinations rewritten by DNAT rules back.
+	origins dnatOrigins
+
+	// flows are keys of flows routed to this entry.
+	flows sync.Map
+}
+
+func newNATEntry(pc net.PacketConn) *natEntry {
+	return &natEntry{
+		PacketConn: pc,
+		filter:     nat.NewFilter(natMode()),
+	}
+}
+
+// contact records the destination of metadata as contacted.
+// Replies to domain come from its resolved address, so domain
+// is resolved when it's contacted the first time.
+func (e *natEntry) contact(metadata *adapter.Metadata) {
+	e.filter.Add(metadata.DstIP, metadata.DstPort)
+	if metadata.Host == "" || natMode() == nat.FullCone {
+		return
+	}
+
+	if _, loaded := e.domains.LoadOrStore(metadata.DestinationAddress(), struct{}{}); loaded {
+		return
+	}
+	if ip, err := resolver.ResolveIP(metadata.Host); err == nil {
+		e.filter.Add(ip, metadata.DstPort)
+	}
+}
+
+// allow reports whether the reply from addr is accepted.
+func (e *natEntry) allow(addr net.Addr) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	return e.filter.Allow(udpAddr.IP, uint16(udpAddr.Port))
+}
+
+// addFlow records that the flow of key is routed to entry.
+func (e *natEntry) addFlow(key string) {
+	e.flows.Store(key, struct{}{})
+}
+
+// forgetFlows removes routes of flows sent through entry, so
+// they're routed again after entry is closed.
+func (e *natEntry) forgetFlows() {
+	e.flows.Range(func(key, _ interface{}) bool {
+		_udpRoutes.Delete(key)
+		return true
+	})
+}
+
+// udpRoute is the routing result of a UDP flow, later packets
+// of the flow reuse it without sniffing and matching again.
+type udpRoute struct {
+	host     string
+	outbound string
+	rule     string
+	timeout  adapter.Timeout
+	tags     map[string]string
+}
+
+func newUDPRoute(metadata *adapter.Metadata) *udpRoute {
+	return &udpRoute{
+		host:     metadata.Host,
+		outbound: metadata.Outbound,
+		rule:     metadata.Rule,
+		timeout:  metadata.Timeout,
+		tags:     metadata.Tags,
+	}
+}
+
+// fill copies the routing result into metadata of the flow.
+func (r *udpRoute) fill(metadata *adapter.Metadata) {
+	if metadata.Host == "" {
+		metadata.Host = r.host
+	}
+	metadata.Outbound = r.outbound
+	metadata.Rule = r.rule
+	metadata.Timeout = r.timeout
+	metadata.Tags = r.tags
+}
+
+var (
+	// _udpRoutes maps flows to their routes, flows from the same
+	// source to different outbounds use separate NAT entries.
+	_udpRoutes sync.Map /* flow key -> *udpRoute */
+
+	// _udpRouting holds flows being routed, later packets wait
+	// for the first one of the flow.
+	_udpRouting sync.Map /* flow key -> *udpRouting */
+)
+
+// udpRouting is the routing of a flow by its first packet, later
+// packets are passed to it for sniffing QUIC ClientHello spanning
+// several datagrams, and wait for the route or rejection.
+type udpRouting struct {
+	done     chan struct{}
+	more     chan []byte
+	rejected bool
+}
+
 func handleUDP(packet adapter.UDPPacket) {
 	metadata := packet.Metadata()
 	if !metadata.Valid() {
@@ -34,6 +148,11 @@ func handleUDP(packet adapter.UDPPacket) {
 		return
 	}
 
+	if shouldHijackDNS(metadata) {
+		hijackUDP(packet)
+		return
+	}
+
 	// make a fAddr if request ip is fake ip.
 	var fAddr net.Addr
 	if resolver.IsExistFakeIP(metadata.DstIP) {
@@ -46,28 +165,59 @@ func handleUDP(packet adapter.UDPPacket) {
 		return
 	}
 
-	key := generateNATKey(metadata)
+	origin := metadata.UDPAddr()
+	flowKey := generateFlowKey(metadata)
+	dnat := rewriteDestination(metadata)
 
-	handle := func(drop bool) bool {
+	handle := func(key string, drop bool) *natEntry {
 		pc := natTable.Get(key)
-		if pc != nil {
-			handleUDPToRemote(packet, pc, metadata /* as net.Addr */, drop)
-			return true
+		if pc == nil {
+			return nil
+		}
+
+		e := pc.(*natEntry)
+		e.contact(metadata)
+		if dnat != nil {
+			e.origins.add(metadata, origin)
+		}
+		handleUDPToRemote(packet, pc, metadata /* as net.Addr */, drop)
+		return e
+	}
+
+	if r, ok := _udpRoutes.Load(flowKey); ok {
+		r.(*udpRoute).fill(metadata)
+		if handle(generateNATKey(metadata), true /* drop */) != nil {
+			return
 		}
-		return false
 	}
 
-	if handle(true /* drop */) {
+	if !_accepting.Load() {
+		packet.Drop()
 		return
 	}
 
-	lockKey := key + "-lock"
-	cond, loaded := natTable.GetOrCreateLock(lockKey)
 	go func() {
+		// keep the original source of replies if the
+		// domain is sniffed, as proxy may resolve it to
+		// another address.
+		sniffed, ok := routeUDP(packet, flowKey, dnat)
+		if !ok {
+			packet.Drop()
+			return
+		}
+		if sniffed && fAddr == nil {
+			fAddr = metadata.UDPAddr()
+		}
+		key := generateNATKey(metadata)
+
+		lockKey := key + "-lock"
+		cond, loaded := natTable.GetOrCreateLock(lockKey)
 		if loaded {
 			cond.L.Lock()
 			cond.Wait()
-			handle(true) /* drop after sending data to remote */
+			if e := handle(key, true /* drop after sending data to remote */); e != nil {
+				e.addFlow(flowKey)
+			}
 			cond.L.Unlock()
 			return
 		}
@@ -77,9 +227,36 @@ func handleUDP(packet adapter.UDPPacket) {
 			cond.Broadcast()
 		}()
 
+		if e := handle(key, true); e != nil { /* created while routing */
+			e.addFlow(flowKey)
+			return
+		}
+
+		release, reason := _limiter.acquire(metadata)
+		if release == nil {
+			_udpRoutes.Delete(flowKey)
+			log.Warnf("[UDP] %s --> %s rejected: %s limit reached", metadata.SourceAddress(), metadata.DestinationAddress(), reason)
+			packet.Drop()
+			return
+		}
+
+		// kill switch may engage while routing or calling hooks,
+		// and the flow may be routed to an outbound bypassing the
+		// proxy, so check it again.
+		if KillSwitchEngaged() {
+			release()
+			_udpRoutes.Delete(flowKey)
+			packet.Drop()
+			return
+		}
+
+		start := time.Now()
 		pc, err := proxy.DialUDP(metadata)
 		if err != nil {
+			release()
+			_udpRoutes.Delete(flowKey)
 			log.Warnf("[UDP] dial %s error: %v", metadata.DestinationAddress(), err)
+			logDialError(metadata, start, err)
 			return
 		}
 
@@ -93,21 +270,94 @@ func handleUDP(packet adapter.UDPPacket) {
 			metadata.MidPort = uint16(port)
 		}
 
-		pc = manager.NewUDPTracker(pc, metadata)
+		e := newNATEntry(manager.NewUDPTracker(pc, metadata))
+		if KillSwitchEngaged() { /* engaged before tracked */
+			release()
+			_udpRoutes.Delete(flowKey)
+			e.Close()
+			packet.Drop()
+			return
+		}
+		e.addFlow(flowKey)
+		if dnat != nil {
+			e.origins.add(metadata, origin)
+		}
+		onDialed(metadata, e)
 
 		go func() {
-			defer pc.Close()
+			defer release()
+			defer e.Close()
 			defer packet.Drop()
+			defer e.forgetFlows()
 			defer natTable.Delete(key)
 
-			handleUDPToLocal(packet, pc, fAddr, udpTimeout)
+			handleUDPToLocal(packet, e, fAddr, metadata.Timeout.Idle)
 		}()
 
-		natTable.Set(key, pc)
-		handle(false /* drop */)
+		natTable.Set(key, e)
+		handle(key, false /* drop */)
 	}()
 }
 
+// routeUDP routes the flow of packet and records the route, the
+// route of a flow routed by another packet meanwhile is reused.
+// It reports whether the domain is sniffed, ok is false if the
+// flow is rejected by its domain or denied by hook. The route is
+// recorded after hook, so it holds the outbound chosen by hook.
+func routeUDP(packet adapter.UDPPacket, flowKey string, dnat *DNATRule) (sniffed, ok bool) {
+	metadata := packet.Metadata()
+
+	routing := &udpRouting{
+		done: make(chan struct{}),
+		more: make(chan []byte, maxSniffDatagrams),
+	}
+	if v, loaded := _udpRouting.LoadOrStore(flowKey, routing); loaded {
+		r := v.(*udpRouting)
+		select {
+		case r.more <- packet.Data(): /* valid until the packet is dropped */
+		default:
+		}
+		<-r.done
+		if r.rejected {
+			return false, false
+		}
+	} else {
+		defer func() {
+			_udpRouting.Delete(flowKey)
+			close(routing.done)
+		}()
+	}
+
+	if r, ok := _udpRoutes.Load(flowKey); ok {
+		r.(*udpRoute).fill(metadata)
+		return false, true
+	}
+
+	/* rewritten destinations are not sniffed to keep the DNAT target */
+	if dnat == nil {
+		var host string
+		host, sniffed = sniffUDP(packet, routing.more)
+		if blockDNSLeakHost(metadata, host) || blockUDPHost(metadata, host) {
+			routing.rejected = true
+			return false, false
+		}
+	}
+
+	lookupProcess(metadata)
+	routeMetadata(metadata)
+	routeDNAT(metadata, dnat)
+	applyTimeout(metadata)
+
+	if !onAccept(metadata) {
+		routing.rejected = true
+		log.Infof("[UDP] %s --> %s denied by hook", metadata.SourceAddress(), metadata.DestinationAddress())
+		return false, false
+	}
+
+	_udpRoutes.Store(flowKey, newUDPRoute(metadata))
+	return sniffed, true
+}
+
 func handleUDPToRemote(packet adapter.UDPPacket, pc net.PacketConn, remote net.Addr, drop bool) {
 	defer func() {
 		if drop {
@@ -122,7 +372,7 @@ func handleUDPToRemote(packet adapter.UDPPacket, pc net.PacketConn, remote net.A
 	log.Infof("[UDP] %s --> %s", packet.RemoteAddr(), remote)
 }
 
-func handleUDPToLocal(packet adapter.UDPPacket, pc net.PacketConn, fAddr net.Addr, timeout time.Duration) {
+func handleUDPToLocal(packet adapter.UDPPacket, pc *natEntry, fAddr net.Addr, timeout time.Duration) {
 	buf := pool.Get(udpBufferSize)
 	defer pool.Put(buf)
 
@@ -136,7 +386,14 @@ func handleUDPToLocal(packet adapter.UDPPacket, pc net.PacketConn, fAddr net.Add
 			return
 		}
 
-		if fAddr != nil {
+		if !pc.allow(from) {
+			log.Debugf("[UDP] %s <-- %s filtered by %s NAT", packet.RemoteAddr(), from, natMode())
+			continue
+		}
+
+		if origin, ok := pc.origins.lookup(from); ok {
+			from = origin
+		} else if fAddr != nil {
 			from = fAddr
 		}
 
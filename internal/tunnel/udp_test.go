@@ -0,0 +1,224 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/manager"
+	"github.com/xjasonlyu/tun2socks/internal/proxy"
+	"github.com/xjasonlyu/tun2socks/pkg/nat"
+)
+
+const replyTimeout = 200 * time.Millisecond
+
+// testPacket is a UDP packet from the stack, replies written
+// back are sent to replies.
+type testPacket struct {
+	metadata *adapter.Metadata
+	data     []byte
+	replies  chan<- testReply
+}
+
+type testReply struct {
+	data string
+	from string
+}
+
+func (p *testPacket) Data() []byte                { return p.data }
+func (p *testPacket) Drop()                       {}
+func (p *testPacket) Metadata() *adapter.Metadata { return p.metadata }
+
+func (p *testPacket) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: p.metadata.SrcIP, Port: int(p.metadata.SrcPort)}
+}
+
+func (p *testPacket) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: p.metadata.DstIP, Port: int(p.metadata.DstPort)}
+}
+
+func (p *testPacket) WriteBack(b []byte, addr net.Addr) (int, error) {
+	p.replies <- testReply{data: string(b), from: addr.String()}
+	return len(b), nil
+}
+
+// listenEcho starts a UDP server on ip which replies the source
+// address of each packet.
+func listenEcho(t testing.TB, ip string) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte(addr.String()), addr)
+		}
+	}()
+	return conn
+}
+
+func sendPacket(src uint16, dst *net.UDPConn, data string, replies chan<- testReply) {
+	addr := dst.LocalAddr().(*net.UDPAddr)
+	handleUDP(&testPacket{
+		metadata: &adapter.Metadata{
+			Net:     adapter.UDP,
+			SrcIP:   net.IPv4(10, 0, 0, 1),
+			SrcPort: src,
+			DstIP:   addr.IP,
+			DstPort: uint16(addr.Port),
+		},
+		data:    []byte(data),
+		replies: replies,
+	})
+}
+
+func receive(replies <-chan testReply) (testReply, bool) {
+	select {
+	case r := <-replies:
+		return r, true
+	case <-time.After(replyTimeout):
+		return testReply{}, false
+	}
+}
+
+func TestNATModes(t *testing.T) {
+	if err := proxy.Register("direct://"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetUDPNATMode(nat.FullCone)
+
+	tests := []struct {
+		mode nat.Mode
+
+		sameMapping bool
+		/* unsolicited packets to the mapped address */
+		fromSameIP  bool
+		fromOtherIP bool
+	}{
+		{nat.FullCone, true, true, true},
+		{nat.Restricted, true, true, false},
+		{nat.PortRestricted, true, false, false},
+		{nat.Symmetric, false, false, false},
+	}
+
+	for i, tt := range tests {
+		tt, src := tt, uint16(10000+i)
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			SetUDPNATMode(tt.mode)
+
+			a, b := listenEcho(t, "127.0.0.1"), listenEcho(t, "127.0.0.1")
+			sameIP, otherIP := listenEcho(t, "127.0.0.1"), listenEcho(t, "127.0.0.2")
+
+			replies := make(chan testReply, 4)
+			sendPacket(src, a, "a", replies)
+			ra, ok := receive(replies)
+			if !ok {
+				t.Fatal("no reply from a")
+			}
+			if ra.from != a.LocalAddr().String() {
+				t.Fatalf("reply from %s, want %s", ra.from, a.LocalAddr())
+			}
+
+			sendPacket(src, b, "b", replies)
+			rb, ok := receive(replies)
+			if !ok {
+				t.Fatal("no reply from b")
+			}
+			if got := ra.data == rb.data; got != tt.sameMapping {
+				t.Errorf("mapped to %s and %s, same mapping %v, want %v", ra.data, rb.data, got, tt.sameMapping)
+			}
+
+			mapped, err := net.ResolveUDPAddr("udp", ra.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range []struct {
+				conn *net.UDPConn
+				want bool
+			}{
+				{sameIP, tt.fromSameIP},
+				{otherIP, tt.fromOtherIP},
+			} {
+				if _, err := c.conn.WriteTo([]byte("x"), mapped); err != nil {
+					t.Fatal(err)
+				}
+				if _, got := receive(replies); got != c.want {
+					t.Errorf("packet from %s accepted %v, want %v", c.conn.LocalAddr(), got, c.want)
+				}
+			}
+		})
+	}
+}
+
+// outboundHook overrides the outbound of flows to port.
+type outboundHook struct {
+	port     uint16
+	outbound string
+}
+
+func (h *outboundHook) OnAccept(_ context.Context, metadata *adapter.Metadata) Verdict {
+	if metadata.DstPort == h.port {
+		return Verdict{Outbound: h.outbound}
+	}
+	return Verdict{}
+}
+
+func (h *outboundHook) OnDialed(context.Context, *adapter.Metadata, DialedConn) {}
+
+func (h *outboundHook) OnClosed(context.Context, *adapter.Metadata, manager.Stats) {}
+
+func TestHookOutbound(t *testing.T) {
+	if err := proxy.Register("direct://"); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := listenEcho(t, "127.0.0.1"), listenEcho(t, "127.0.0.1")
+	hook := &outboundHook{
+		port:     uint16(a.LocalAddr().(*net.UDPAddr).Port),
+		outbound: proxy.OutboundDirect,
+	}
+	if err := SetHook(hook, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer SetHook(nil, 0)
+
+	const src = 11000
+	replies := make(chan testReply, 4)
+	sendPacket(src, a, "a", replies)
+	ra, ok := receive(replies)
+	if !ok {
+		t.Fatal("no reply from a")
+	}
+
+	key := "10.0.0.1:11000/" + proxy.OutboundDirect
+	if natTable.Get(key) == nil {
+		t.Fatalf("no NAT entry of %s", key)
+	}
+
+	/* not overridden, so it mustn't reuse the entry of a */
+	sendPacket(src, b, "b", replies)
+	rb, ok := receive(replies)
+	if !ok {
+		t.Fatal("no reply from b")
+	}
+	if ra.data == rb.data {
+		t.Errorf("flows to %s and %s mapped to %s, want different entries", proxy.OutboundDirect, proxy.OutboundProxy, ra.data)
+	}
+
+	/* later packets of a reuse its entry */
+	sendPacket(src, a, "a", replies)
+	if r, ok := receive(replies); !ok || r.data != ra.data {
+		t.Errorf("later packet to a mapped to %s, want %s", r.data, ra.data)
+	}
+}
@@ -2,13 +2,90 @@ package tunnel
 
 import (
 	"fmt"
+	"net"
+	"time"
 
+	"go.uber.org/atomic"
+
+	"github.com/xjasonlyu/clash/common/cache"
 	"github.com/xjasonlyu/clash/component/resolver"
+	"github.com/xjasonlyu/tun2socks/internal/accesslog"
 	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/proxy"
+	"github.com/xjasonlyu/tun2socks/internal/rule"
+	"github.com/xjasonlyu/tun2socks/pkg/log"
+	"github.com/xjasonlyu/tun2socks/pkg/nat"
+	P "github.com/xjasonlyu/tun2socks/pkg/process"
+)
+
+const (
+	flowVerdictAge  = 60 /* seconds */
+	maxFlowVerdicts = 4096
 )
 
+// _findProcess enables process lookup for every connection.
+var _findProcess = atomic.NewBool(false)
+
+// SetFindProcess enables or disables process lookup, it's
+// always enabled if rules match by process information.
+func SetFindProcess(v bool) {
+	_findProcess.Store(v)
+}
+
+// _natMode is the NAT mode of UDP, full cone by default.
+var _natMode atomic.Value
+
+func init() {
+	_natMode.Store(nat.FullCone)
+}
+
+// SetUDPNATMode sets the NAT mode of new UDP sessions.
+func SetUDPNATMode(mode nat.Mode) {
+	_natMode.Store(mode)
+}
+
+func natMode() nat.Mode {
+	return _natMode.Load().(nat.Mode)
+}
+
+// generateNATKey returns the key of NAT entry of metadata, flows
+// routed to different outbounds never share a NAT entry.
 func generateNATKey(m *adapter.Metadata) string {
-	return m.SourceAddress() /* Full Cone NAT Key */
+	if natMode() == nat.Symmetric {
+		return m.SourceAddress() + "/" + m.UDPAddr().String() + "/" + m.Outbound
+	}
+	return m.SourceAddress() + "/" + m.Outbound /* Cone NAT Key */
+}
+
+// generateFlowKey returns the key of UDP flow of metadata by its
+// original destination.
+func generateFlowKey(m *adapter.Metadata) string {
+	return m.SourceAddress() + "/" + m.DestinationAddress()
+}
+
+// flowID identifies a UDP flow by its original addresses like
+// flow key, but it's cheap enough to compute for every packet.
+type flowID struct {
+	srcIP, dstIP     [net.IPv6len]byte
+	srcPort, dstPort uint16
+}
+
+func newFlowID(m *adapter.Metadata) flowID {
+	id := flowID{srcPort: m.SrcPort, dstPort: m.DstPort}
+	copy(id.srcIP[:], m.SrcIP.To16())
+	copy(id.dstIP[:], m.DstIP.To16())
+	return id
+}
+
+// newFlowVerdicts returns a cache of verdicts of UDP flows by
+// flowID, so later packets of a flow are rejected without being
+// inspected again.
+func newFlowVerdicts() *cache.LruCache {
+	return cache.NewLRUCache(
+		cache.WithAge(flowVerdictAge),
+		cache.WithSize(maxFlowVerdicts),
+		cache.WithUpdateAgeOnGet(),
+	)
 }
 
 func max(a, b int) int {
@@ -33,3 +110,50 @@ func resolveMetadata(metadata *adapter.Metadata) error {
 
 	return nil
 }
+
+// routeMetadata matches metadata against rules and records
+// the chosen outbound. Connections routed to PROXY, or not
+// matched by any rule, go through the outbound of client.
+func routeMetadata(metadata *adapter.Metadata) {
+	outbound := proxy.OutboundProxy
+	if r := rule.Match(metadata); r != nil {
+		metadata.Rule = rule.String(r)
+		outbound = r.Outbound()
+	}
+
+	if outbound == proxy.OutboundProxy {
+		outbound = rule.ClientOutbound(metadata.SrcIP)
+	}
+	metadata.Outbound = outbound
+}
+
+// lookupProcess records the local process owning the connection.
+func lookupProcess(metadata *adapter.Metadata) {
+	if !_findProcess.Load() && !rule.NeedProcess() {
+		return
+	}
+
+	p, err := P.Find(metadata.Network(), metadata.SrcIP, metadata.SrcPort)
+	if err != nil {
+		log.Debugf("[Process] find %s %s error: %v", metadata.Network(), metadata.SourceAddress(), err)
+		return
+	}
+
+	metadata.Process = p.Name
+	metadata.PID = p.PID
+	metadata.UID = p.UID
+}
+
+// logDialError writes access log entry of the failed dial
+// started at start.
+func logDialError(metadata *adapter.Metadata, start time.Time, err error) {
+	if !accesslog.Enabled() {
+		return
+	}
+
+	e := accesslog.NewEntry(metadata)
+	e.Start = start
+	e.End = time.Now()
+	e.Error = err.Error()
+	accesslog.Write(e)
+}
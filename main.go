@@ -23,13 +23,55 @@ func main() {
 		Version: Version,
 		Action:  cmd.Main,
 		Flags: []cli.Flag{
+			&cmd.AccessLog,
+			&cmd.AccessLogBackups,
+			&cmd.AccessLogMaxSize,
 			&cmd.API,
+			&cmd.Bandwidth,
+			&cmd.BandwidthOutbound,
+			&cmd.BandwidthPerSource,
+			&cmd.BreakerBackoff,
+			&cmd.BreakerThreshold,
+			&cmd.Client,
 			&cmd.Device,
+			&cmd.DNAT,
 			&cmd.DNS,
+			&cmd.DNSHijack,
+			&cmd.DNSLeak,
+			&cmd.DNSLeakDoHDomains,
+			&cmd.DNSLeakDoHIPs,
+			&cmd.DrainTimeout,
+			&cmd.Fallback,
+			&cmd.FindProcess,
+			&cmd.HealthCheckInterval,
+			&cmd.HealthCheckURL,
 			&cmd.Hosts,
 			&cmd.Interface,
+			&cmd.KillSwitch,
 			&cmd.LogLevel,
+			&cmd.MaxConnections,
+			&cmd.MaxConnectionsPerDestination,
+			&cmd.MaxConnectionsPerSource,
+			&cmd.Outbound,
+			&cmd.PortTimeout,
 			&cmd.Proxy,
+			&cmd.RejectIPv6,
+			&cmd.Rule,
+			&cmd.RuleSet,
+			&cmd.SniffPorts,
+			&cmd.TCPConnectTimeout,
+			&cmd.TCPDeferHandshake,
+			&cmd.TCPEarlyData,
+			&cmd.TCPIdleTimeout,
+			&cmd.TCPKeepaliveIdle,
+			&cmd.TCPKeepaliveInterval,
+			&cmd.TCPWaitTimeout,
+			&cmd.UDPBlockDomains,
+			&cmd.UDPBlockPorts,
+			&cmd.UDPNAT,
+			&cmd.UDPQueueSize,
+			&cmd.UDPTimeout,
+			&cmd.UDPWorkers,
 			&cmd.Version,
 		},
 		HideVersion:     true,
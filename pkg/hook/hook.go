@@ -0,0 +1,38 @@
+// Package hook exports lifecycle hooks of flows for programs
+// embedding tun2socks, to inspect, deny or tag flows.
+package hook
+
+import (
+	"time"
+
+	"github.com/xjasonlyu/tun2socks/internal/adapter"
+	"github.com/xjasonlyu/tun2socks/internal/manager"
+	"github.com/xjasonlyu/tun2socks/internal/tunnel"
+)
+
+type (
+	Hook       = tunnel.Hook
+	Verdict    = tunnel.Verdict
+	DialedConn = tunnel.DialedConn
+	Status     = tunnel.HookStatus
+	Metadata   = adapter.Metadata
+	Stats      = manager.Stats
+)
+
+// Kinds of hook calls.
+const (
+	Accept = tunnel.HookAccept
+	Dialed = tunnel.HookDialed
+	Closed = tunnel.HookClosed
+)
+
+// Set sets hook of flows with timeout of each call, zero
+// timeout means one second, nil hook removes the current one.
+func Set(h Hook, timeout time.Duration) error {
+	return tunnel.SetHook(h, timeout)
+}
+
+// GetStatus returns latency of hook calls by kind.
+func GetStatus() map[string]Status {
+	return tunnel.GetHookStatus()
+}
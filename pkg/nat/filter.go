@@ -0,0 +1,63 @@
+package nat
+
+import (
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Filter records external endpoints contacted by internal
+// host, and decides whether packets from an external endpoint
+// are accepted according to the filtering behavior of mode.
+type Filter struct {
+	mode Mode
+
+	mu        sync.RWMutex
+	endpoints map[string]struct{}
+}
+
+func NewFilter(mode Mode) *Filter {
+	return &Filter{
+		mode:      mode,
+		endpoints: make(map[string]struct{}),
+	}
+}
+
+// Add records endpoint ip:port as contacted.
+func (f *Filter) Add(ip net.IP, port uint16) {
+	if f.mode == FullCone {
+		return
+	}
+
+	key := f.key(ip, port)
+
+	f.mu.RLock()
+	_, ok := f.endpoints[key]
+	f.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	f.mu.Lock()
+	f.endpoints[key] = struct{}{}
+	f.mu.Unlock()
+}
+
+// Allow reports whether packets from ip:port are accepted.
+func (f *Filter) Allow(ip net.IP, port uint16) bool {
+	if f.mode == FullCone {
+		return true
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	_, ok := f.endpoints[f.key(ip, port)]
+	return ok
+}
+
+func (f *Filter) key(ip net.IP, port uint16) string {
+	if f.mode == Restricted {
+		return ip.String()
+	}
+	return net.JoinHostPort(ip.String(), strconv.FormatUint(uint64(port), 10))
+}
@@ -0,0 +1,47 @@
+package nat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Mode is the mapping and filtering behavior of NAT.
+type Mode int
+
+const (
+	FullCone Mode = iota
+	Restricted
+	PortRestricted
+	Symmetric
+)
+
+func (m Mode) String() string {
+	switch m {
+	case FullCone:
+		return "fullcone"
+	case Restricted:
+		return "restricted"
+	case PortRestricted:
+		return "port-restricted"
+	case Symmetric:
+		return "symmetric"
+	default:
+		return fmt.Sprintf("mode(%d)", int(m))
+	}
+}
+
+// ParseMode parses NAT mode by its name.
+func ParseMode(s string) (Mode, error) {
+	switch strings.ToLower(s) {
+	case "fullcone", "full-cone":
+		return FullCone, nil
+	case "restricted":
+		return Restricted, nil
+	case "port-restricted":
+		return PortRestricted, nil
+	case "symmetric":
+		return Symmetric, nil
+	default:
+		return FullCone, fmt.Errorf("unsupported NAT mode: %s", s)
+	}
+}
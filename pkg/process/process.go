@@ -0,0 +1,28 @@
+// Package process finds the local process owning a socket.
+package process
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	ErrInvalidNetwork = errors.New("invalid network")
+	ErrNotFound       = errors.New("process not found")
+)
+
+// Process describes the owner of a socket.
+type Process struct {
+	Name string
+	PID  uint32
+	UID  uint32
+}
+
+// Find returns the process which owns the socket bound to
+// local address ip:port of network (tcp or udp).
+func Find(network string, ip net.IP, port uint16) (*Process, error) {
+	if network != "tcp" && network != "udp" {
+		return nil, ErrInvalidNetwork
+	}
+	return findProcess(network, ip, port)
+}
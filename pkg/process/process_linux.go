@@ -0,0 +1,267 @@
+package process
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"github.com/xjasonlyu/clash/common/cache"
+)
+
+const (
+	procRoot = "/proc"
+
+	// processCacheAge is the time owners of socket inodes are
+	// cached, a socket rarely changes its owner.
+	processCacheAge = 5 /* seconds */
+	maxProcessCache = 1024
+
+	// maxRecentPIDs is the number of recent socket owners to
+	// check before walking all processes.
+	maxRecentPIDs = 16
+)
+
+var (
+	// _processes caches owners by socket inode, UDP sockets are
+	// looked up once for flows to many destinations.
+	_processes = cache.NewLRUCache(cache.WithAge(processCacheAge), cache.WithSize(maxProcessCache))
+
+	// _recentPIDs are owners of sockets found recently, most
+	// recent first. New sockets are likely opened by them, so
+	// the walk of all processes is usually skipped.
+	_recentPIDs []string
+	_recentMu   sync.Mutex
+)
+
+// littleEndian reports whether the host is little endian, which
+// determines how the kernel prints addresses in /proc/net/*.
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+func findProcess(network string, ip net.IP, port uint16) (*Process, error) {
+	inode, uid, err := findSocket(network, ip, port)
+	if err != nil {
+		return nil, err
+	}
+
+	if p, ok := _processes.Get(inode); ok {
+		cp := *p.(*Process)
+		return &cp, nil
+	}
+
+	pid, err := findPIDByInode(inode, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Process{
+		Name: processName(pid),
+		PID:  pid,
+		UID:  uid,
+	}
+	_processes.Set(inode, p)
+
+	cp := *p
+	return &cp, nil
+}
+
+// findSocket scans /proc/net/{tcp,udp}[6] for the socket bound
+// to ip:port, and returns its inode and owner uid.
+func findSocket(network string, ip net.IP, port uint16) (inode uint64, uid uint32, err error) {
+	for _, table := range []string{network, network + "6"} {
+		inode, uid, err = scanSocketTable(filepath.Join(procRoot, "net", table), ip, port)
+		if err == nil {
+			return
+		}
+		if err != ErrNotFound {
+			return
+		}
+	}
+	return 0, 0, ErrNotFound
+}
+
+func scanSocketTable(path string, ip net.IP, port uint16) (uint64, uint32, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, ErrNotFound
+		}
+		return 0, 0, err
+	}
+
+	// fallback is a socket bound to the unspecified address
+	// with the same port, e.g. an unconnected UDP socket.
+	var (
+		fallbackInode uint64
+		fallbackUID   uint32
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Scan() /* skip header line */
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue
+		// tr:tm->when retrnsmt uid timeout inode ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		localIP, localPort, err := parseAddr(fields[1])
+		if err != nil || localPort != port {
+			continue
+		}
+
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || inode == 0 {
+			continue
+		}
+
+		uid, err := strconv.ParseUint(fields[7], 10, 32)
+		if err != nil {
+			continue
+		}
+
+		if localIP.Equal(ip) {
+			return inode, uint32(uid), nil
+		}
+
+		if localIP.IsUnspecified() && fallbackInode == 0 {
+			fallbackInode, fallbackUID = inode, uint32(uid)
+		}
+	}
+
+	if fallbackInode != 0 {
+		return fallbackInode, fallbackUID, nil
+	}
+	return 0, 0, ErrNotFound
+}
+
+// parseAddr parses address formatted as 0100007F:1F90, each
+// 32-bit word of IP is printed in host byte order.
+func parseAddr(s string) (net.IP, uint16, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, 0, fmt.Errorf("invalid address: %s", s)
+	}
+
+	ip, err := hex.DecodeString(s[:i])
+	if err != nil || (len(ip) != net.IPv4len && len(ip) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid address: %s", s)
+	}
+
+	if littleEndian {
+		for w := 0; w < len(ip); w += 4 {
+			ip[w], ip[w+1], ip[w+2], ip[w+3] = ip[w+3], ip[w+2], ip[w+1], ip[w]
+		}
+	}
+
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid address: %s", s)
+	}
+
+	return net.IP(ip), uint16(port), nil
+}
+
+// findPIDByInode finds the process holding socket inode, recent
+// owners are checked first, then it walks /proc/*/fd, only
+// processes owned by uid are checked.
+func findPIDByInode(inode uint64, uid uint32) (uint32, error) {
+	target := fmt.Sprintf("socket:[%d]", inode)
+	for _, pid := range recentPIDs() {
+		if holdsSocket(pid, target) {
+			addRecentPID(pid)
+			return parsePID(pid)
+		}
+	}
+
+	procs, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, fi := range procs {
+		if _, err := parsePID(fi.Name()); err != nil || !fi.IsDir() {
+			continue
+		}
+
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Uid != uid {
+			continue
+		}
+
+		if holdsSocket(fi.Name(), target) {
+			addRecentPID(fi.Name())
+			return parsePID(fi.Name())
+		}
+	}
+
+	return 0, ErrNotFound
+}
+
+func parsePID(s string) (uint32, error) {
+	pid, err := strconv.ParseUint(s, 10, 32)
+	return uint32(pid), err
+}
+
+// holdsSocket reports whether any fd of pid links to target.
+func holdsSocket(pid, target string) bool {
+	fdDir := filepath.Join(procRoot, pid, "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return false
+	}
+
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err == nil && link == target {
+			return true
+		}
+	}
+	return false
+}
+
+func recentPIDs() []string {
+	_recentMu.Lock()
+	defer _recentMu.Unlock()
+	return append([]string(nil), _recentPIDs...)
+}
+
+func addRecentPID(pid string) {
+	_recentMu.Lock()
+	defer _recentMu.Unlock()
+
+	pids := []string{pid}
+	for _, p := range _recentPIDs {
+		if p != pid && len(pids) < maxRecentPIDs {
+			pids = append(pids, p)
+		}
+	}
+	_recentPIDs = pids
+}
+
+// processName returns the executable name of pid, comm is
+// used instead if the executable link is not accessible.
+func processName(pid uint32) string {
+	dir := filepath.Join(procRoot, strconv.FormatUint(uint64(pid), 10))
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		return filepath.Base(strings.TrimSuffix(exe, " (deleted)"))
+	}
+
+	comm, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
@@ -0,0 +1,13 @@
+// +build !linux
+
+package process
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func findProcess(_ string, _ net.IP, _ uint16) (*Process, error) {
+	return nil, fmt.Errorf("operation was not supported on %s", runtime.GOOS)
+}